@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// clientOptions defines the HTTP client of a sender, with its basic
+// authentication and TLS settings.
+type clientOptions struct {
+	timeout time.Duration
+
+	username     string
+	passwordFile string
+
+	caFile             string
+	certFile           string
+	keyFile            string
+	serverName         string
+	insecureSkipVerify bool
+}
+
+// addFlags adds the flags of the client, prefixed with the sender name,
+// timeoutHelp describes what the timeout bounds.
+func (c *clientOptions) addFlags(flags *pflag.FlagSet, prefix, timeoutHelp string) {
+	flags.DurationVar(&c.timeout, prefix+".timeout", 10*time.Second, timeoutHelp)
+	flags.StringVar(&c.username, prefix+".basic-auth.username", "", "Username for basic authentication.")
+	flags.StringVar(&c.passwordFile, prefix+".basic-auth.password-file", "",
+		"File containing the password for basic authentication, it is read again for every request.")
+	flags.StringVar(&c.caFile, prefix+".tls.ca-file", "", "CA certificate to verify the server certificate with.")
+	flags.StringVar(&c.certFile, prefix+".tls.cert-file", "", "Client certificate file for TLS authentication.")
+	flags.StringVar(&c.keyFile, prefix+".tls.key-file", "", "Client key file for TLS authentication.")
+	flags.StringVar(&c.serverName, prefix+".tls.server-name", "", "Server name to verify the server certificate with.")
+	flags.BoolVar(&c.insecureSkipVerify, prefix+".tls.insecure-skip-verify", false, "Skip the verification of the server certificate.")
+}
+
+// newClient returns the HTTP client.
+func (c *clientOptions) newClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         c.serverName,
+		InsecureSkipVerify: c.insecureSkipVerify,
+	}
+	if c.caFile != "" {
+		ca, err := ioutil.ReadFile(c.caFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("No certificate found in %s", c.caFile)
+		}
+	}
+	if (c.certFile == "") != (c.keyFile == "") {
+		return nil, fmt.Errorf("Both the TLS certificate and key files are needed")
+	}
+	if c.certFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	var rt http.RoundTripper = transport
+	if c.username != "" {
+		rt = &basicAuthRoundTripper{username: c.username, passwordFile: c.passwordFile, next: rt}
+	}
+	return &http.Client{Transport: rt, Timeout: c.timeout}, nil
+}
+
+// basicAuthRoundTripper adds basic authentication to the requests.
+type basicAuthRoundTripper struct {
+	username     string
+	passwordFile string
+	next         http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (rt *basicAuthRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	var password string
+	if rt.passwordFile != "" {
+		b, err := ioutil.ReadFile(rt.passwordFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the basic auth password file %s: %s", rt.passwordFile, err)
+		}
+		password = strings.TrimSpace(string(b))
+	}
+	req = req.Clone(req.Context())
+	req.SetBasicAuth(rt.username, password)
+	return rt.next.RoundTrip(req)
+}
+
+// redactURL returns the URL without its user information, to log it or use
+// it as a label value.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+	u.User = nil
+	return u.String()
+}
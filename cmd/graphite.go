@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+)
+
+// graphiteOptions defines the Graphite sink.
+type graphiteOptions struct {
+	address  string
+	interval time.Duration
+	timeout  time.Duration
+	prefix   string
+	template string
+	tags     bool
+}
+
+func (g *graphiteOptions) addFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&g.address, "graphite.address", "",
+		"Address of the Graphite plaintext (carbon) listener to send the metrics to over TCP, the sink is disabled if empty.")
+	flags.DurationVar(&g.interval, "graphite.interval", time.Minute, "Interval between the sends to Graphite.")
+	flags.DurationVar(&g.timeout, "graphite.timeout", 10*time.Second, "Timeout of a send to Graphite.")
+	flags.StringVar(&g.prefix, "graphite.prefix", "", "Prefix of the Graphite paths.")
+	flags.StringVar(&g.template, "graphite.template", "{__name__}",
+		"Template of the Graphite paths, {label} is replaced by the value of the label. The other labels are appended as .label.value.")
+	flags.BoolVar(&g.tags, "graphite.tags", false, "Append the labels which aren't in the template as Graphite tags (;label=value) instead.")
+}
+
+// graphiteSink sends the metrics of the collectors in the Graphite plaintext
+// protocol, a "path value timestamp" line per series.
+type graphiteSink struct {
+	opts     *graphiteOptions
+	template *pathTemplate
+	target   *scrapeTarget
+	logger   *zap.Logger
+}
+
+func (h *handler) newGraphiteSink(opts *graphiteOptions, logger *zap.Logger) (*graphiteSink, error) {
+	if opts.interval <= 0 {
+		return nil, fmt.Errorf("Invalid --graphite.interval %s", opts.interval)
+	}
+	template, err := newPathTemplate(opts.prefix, opts.template)
+	if err != nil {
+		return nil, err
+	}
+	return &graphiteSink{
+		opts:     opts,
+		template: template,
+		target:   h.unfiltered,
+		logger:   logger.With(zap.String("sender", "graphite"), zap.String("address", opts.address)),
+	}, nil
+}
+
+func (g *graphiteSink) run(ctx context.Context) {
+	every(ctx, g.opts.interval, g.send)
+}
+
+func (g *graphiteSink) close(context.Context) {}
+
+func (g *graphiteSink) send(ctx context.Context) {
+	now := time.Now()
+	mfs := gather(ctx, g.target, g.logger)
+	ctx, cancel := context.WithTimeout(ctx, g.opts.timeout)
+	defer cancel()
+	g.write(ctx, mfs, now)
+}
+
+// write sends the metric families to Graphite, with the timestamp of now
+// unless the metrics have their own.
+func (g *graphiteSink) write(ctx context.Context, mfs []*dto.MetricFamily, now time.Time) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", g.opts.address)
+	if err != nil {
+		g.logger.Warn("failed to connect to Graphite", zap.Error(err))
+		return
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	w := bufio.NewWriter(conn)
+	lines := 0
+	for _, s := range toTimeSeries(mfs, nil, now.UnixNano()/int64(time.Millisecond)) {
+		if !isFinite(s.value) {
+			continue
+		}
+		path, unused := g.template.render(s.labels)
+		for _, l := range unused {
+			if g.opts.tags {
+				path += ";" + l.name + "=" + sanitizePath(l.value)
+			} else {
+				path += "." + sanitizePath(l.name) + "." + sanitizePath(l.value)
+			}
+		}
+		fmt.Fprintf(w, "%s %s %d\n", path, strconv.FormatFloat(s.value, 'g', -1, 64), s.timestampMs/1000)
+		lines++
+	}
+	if err := w.Flush(); err != nil {
+		g.logger.Warn("failed to send the metrics to Graphite", zap.Error(err))
+		return
+	}
+	g.logger.Debug("sent the metrics to Graphite", zap.Int("lines", lines))
+}
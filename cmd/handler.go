@@ -0,0 +1,311 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+
+	"github.com/microyahoo/fs_exporter/collector"
+)
+
+// handler serves the metrics of the enabled collectors, or of the ones
+// selected by the collect[] and exclude[] query parameters.
+type handler struct {
+	handlerOptions
+	logger *zap.Logger
+
+	// exporterMetricsRegistry is a separate registry for the metrics about
+	// the exporter itself.
+	exporterMetricsRegistry *prometheus.Registry
+	// hostInfo is the collector of fs_exporter_host_info, nil if disabled.
+	hostInfo prometheus.Collector
+
+	inFlightSem chan struct{}
+
+	// unfiltered serves the scrapes without collect[] and exclude[].
+	unfiltered *scrapeTarget
+
+	mtx     sync.Mutex
+	targets map[string]*scrapeTarget // keyed by the sorted collector names
+}
+
+// handlerOptions defines the options of the metrics handler.
+type handlerOptions struct {
+	includeExporterMetrics bool
+	maxRequests            int64
+	timeoutOffset          time.Duration
+	// constLabels are added to every metric of the collectors.
+	constLabels prometheus.Labels
+	hostInfo    bool
+}
+
+func newHandler(opts handlerOptions, logger *zap.Logger) (*handler, error) {
+	h := &handler{
+		handlerOptions:          opts,
+		logger:                  logger,
+		exporterMetricsRegistry: prometheus.NewRegistry(),
+		targets:                 make(map[string]*scrapeTarget),
+	}
+	if h.includeExporterMetrics {
+		h.exporterMetricsRegistry.MustRegister(
+			prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+			prometheus.NewGoCollector(),
+		)
+	}
+	if opts.hostInfo {
+		h.hostInfo = collector.NewHostInfoCollector(logger)
+	}
+	if h.maxRequests > 0 {
+		h.inFlightSem = make(chan struct{}, h.maxRequests)
+	}
+	fsc, err := collector.NewFSCollector(logger)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create collector: %s", err)
+	}
+	for n := range fsc.Collectors {
+		logger.Info("Collector", zap.String("collector", n))
+	}
+	if h.unfiltered, err = h.newScrapeTarget(fsc); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// metricsHandler returns the handler to serve, it is instrumented with the
+// promhttp_metric_handler_* metrics unless the exporter metrics are disabled.
+func (h *handler) metricsHandler() http.Handler {
+	if !h.includeExporterMetrics {
+		return h
+	}
+	return promhttp.InstrumentMetricHandler(h.exporterMetricsRegistry, h)
+}
+
+// ServeHTTP implements http.Handler.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.inFlightSem != nil {
+		select {
+		case h.inFlightSem <- struct{}{}:
+			defer func() { <-h.inFlightSem }()
+		default:
+			http.Error(w, fmt.Sprintf("Limit of concurrent requests reached (%d), try again later.", h.maxRequests),
+				http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	collects := r.URL.Query()["collect[]"]
+	excludes := r.URL.Query()["exclude[]"]
+	h.logger.Debug("collect query", zap.Strings("collect", collects), zap.Strings("exclude", excludes))
+
+	filters, err := h.filters(collects, excludes)
+	if err != nil {
+		h.logger.Warn("Invalid collector filter", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	ctx, cancel, err := h.scrapeContext(r)
+	if err != nil {
+		h.logger.Warn("Invalid scrape timeout", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	defer cancel()
+
+	target, err := h.target(filters)
+	if err != nil {
+		h.logger.Error("Couldn't create metrics handler", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("Couldn't create metrics handler: %s", err)))
+		return
+	}
+	target.serveHTTP(ctx, w, r)
+}
+
+// filters returns the collectors to run for the collect[] and exclude[] query
+// parameters, an empty result means all the enabled collectors.
+func (h *handler) filters(collects, excludes []string) ([]string, error) {
+	if len(collects) > 0 && len(excludes) > 0 {
+		return nil, fmt.Errorf("Combined collect and exclude queries are not allowed.")
+	}
+	if len(excludes) == 0 {
+		// Validate the names here, so that only the failures of the
+		// collectors themselves are reported as server errors.
+		for _, name := range collects {
+			if _, ok := h.unfiltered.fsc.Collectors[name]; ok {
+				continue
+			}
+			if err := collector.CheckCollectors(name); err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("disabled collector: %s", name)
+		}
+		return collects, nil
+	}
+	if err := collector.CheckCollectors(excludes...); err != nil {
+		return nil, err
+	}
+	excluded := make(map[string]bool, len(excludes))
+	for _, e := range excludes {
+		excluded[e] = true
+	}
+	var filters []string
+	for name := range h.unfiltered.fsc.Collectors {
+		if !excluded[name] {
+			filters = append(filters, name)
+		}
+	}
+	if len(filters) == 0 {
+		return nil, fmt.Errorf("All enabled collectors are excluded.")
+	}
+	return filters, nil
+}
+
+// scrapeContext derives the deadline of a scrape from the timeout that
+// Prometheus sends in the X-Prometheus-Scrape-Timeout-Seconds header.
+func (h *handler) scrapeContext(r *http.Request) (context.Context, context.CancelFunc, error) {
+	v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if v == "" {
+		ctx, cancel := context.WithCancel(r.Context())
+		return ctx, cancel, nil
+	}
+	seconds, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to parse timeout from Prometheus header: %s", err)
+	}
+	timeout := time.Duration(seconds*float64(time.Second)) - h.timeoutOffset
+	if timeout <= 0 {
+		timeout = time.Duration(seconds * float64(time.Second))
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	return ctx, cancel, nil
+}
+
+// target returns the scrape target of the collectors, it is only created
+// the first time these collectors are requested.
+func (h *handler) target(filters []string) (*scrapeTarget, error) {
+	if len(filters) == 0 {
+		return h.unfiltered, nil
+	}
+	names := append([]string(nil), filters...)
+	sort.Strings(names)
+	key := strings.Join(names, ",")
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if t, ok := h.targets[key]; ok {
+		return t, nil
+	}
+	fsc, err := collector.NewFSCollector(h.logger, filters...)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create collector: %s", err)
+	}
+	h.logger.Debug("Created filtered scrape target", zap.String("collectors", key))
+	t, err := h.newScrapeTarget(fsc)
+	if err != nil {
+		return nil, err
+	}
+	h.targets[key] = t
+	return t, nil
+}
+
+// scrapeTarget serves the metrics of a fixed set of collectors. Its registries
+// are reused across scrapes, each concurrent scrape takes one from the pool.
+type scrapeTarget struct {
+	fsc  *collector.FSCollector
+	pool sync.Pool
+}
+
+func (h *handler) newScrapeTarget(fsc *collector.FSCollector) (*scrapeTarget, error) {
+	t := &scrapeTarget{fsc: fsc}
+	// The first registry reports the invalid constant labels, the
+	// others have the same collectors and can't fail.
+	s, err := h.newScrapeCollector(fsc)
+	if err != nil {
+		return nil, err
+	}
+	t.pool.Put(s)
+	t.pool.New = func() interface{} {
+		s, err := h.newScrapeCollector(fsc)
+		if err != nil {
+			panic(err)
+		}
+		return s
+	}
+	return t, nil
+}
+
+func (h *handler) newScrapeCollector(fsc *collector.FSCollector) (*scrapeCollector, error) {
+	s := &scrapeCollector{fsc: fsc}
+	rgst := prometheus.NewRegistry()
+	rgstr := prometheus.WrapRegistererWith(h.constLabels, rgst)
+	if err := rgstr.Register(s); err != nil {
+		return nil, fmt.Errorf("Couldn't register collector: %s", err)
+	}
+	if h.hostInfo != nil {
+		if err := rgstr.Register(h.hostInfo); err != nil {
+			return nil, fmt.Errorf("Couldn't register host info collector: %s", err)
+		}
+	}
+	opts := promhttp.HandlerOpts{
+		ErrorLog:      zap.NewStdLog(h.logger),
+		ErrorHandling: promhttp.ContinueOnError,
+	}
+	if h.includeExporterMetrics {
+		opts.Registry = h.exporterMetricsRegistry
+	}
+	s.gatherer = prometheus.Gatherers{h.exporterMetricsRegistry, rgst}
+	s.handler = promhttp.HandlerFor(s.gatherer, opts)
+	return s, nil
+}
+
+func (t *scrapeTarget) serveHTTP(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	s := t.pool.Get().(*scrapeCollector)
+	s.ctx = ctx
+	defer func() {
+		s.ctx = nil
+		t.pool.Put(s)
+	}()
+	s.handler.ServeHTTP(w, r)
+}
+
+// gather gathers the metrics bound to ctx, for the senders pushing them.
+func (t *scrapeTarget) gather(ctx context.Context) ([]*dto.MetricFamily, error) {
+	s := t.pool.Get().(*scrapeCollector)
+	s.ctx = ctx
+	defer func() {
+		s.ctx = nil
+		t.pool.Put(s)
+	}()
+	return s.gatherer.Gather()
+}
+
+// scrapeCollector binds the FSCollector to the context of the scrape being served.
+type scrapeCollector struct {
+	fsc      *collector.FSCollector
+	ctx      context.Context
+	gatherer prometheus.Gatherer
+	handler  http.Handler
+}
+
+// Describe implements the prometheus.Collector interface.
+func (s *scrapeCollector) Describe(ch chan<- *prometheus.Desc) {
+	s.fsc.Describe(ch)
+}
+
+// Collect implements the prometheus.Collector interface.
+func (s *scrapeCollector) Collect(ch chan<- prometheus.Metric) {
+	s.fsc.CollectWithContext(s.ctx, ch)
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+
+	"github.com/microyahoo/fs_exporter/collector"
+)
+
+// setCollectorFlags parses the collector flags of args.
+func setCollectorFlags(t *testing.T, args ...string) {
+	t.Helper()
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	collector.AddCollectorFlags(flags)
+	collector.AddExecFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newZfsHandler returns a handler of the zfs collector only.
+func newZfsHandler(t *testing.T) *handler {
+	t.Helper()
+	setCollectorFlags(t, "--collector.zfs")
+	t.Cleanup(func() { setCollectorFlags(t, "--no-collector.zfs") })
+	collector.DisableDefaultCollectors()
+	h, err := newHandler(handlerOptions{}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func scrape(h http.Handler, query string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/metrics?"+query, nil))
+	return w
+}
+
+func TestHandlerFilters(t *testing.T) {
+	h := newZfsHandler(t)
+	for _, tc := range []struct {
+		query string
+		code  int
+		body  string
+	}{
+		{"collect[]=unknown", http.StatusBadRequest, "unknown collector: unknown"},
+		{"collect[]=glusterfs", http.StatusBadRequest, "disabled collector: glusterfs"},
+		{"exclude[]=zfs", http.StatusBadRequest, "All enabled collectors are excluded."},
+		{"exclude[]=unknown", http.StatusBadRequest, "unknown collector: unknown"},
+		{"collect[]=zfs&exclude[]=glusterfs", http.StatusBadRequest, "Combined collect and exclude queries are not allowed."},
+		{"collect[]=zfs", http.StatusOK, `fs_scrape_collector_success{collector="zfs"} 1`},
+		{"exclude[]=glusterfs", http.StatusOK, `fs_scrape_collector_success{collector="zfs"} 1`},
+		{"", http.StatusOK, `fs_scrape_collector_success{collector="zfs"} 1`},
+	} {
+		w := scrape(h, tc.query)
+		if w.Code != tc.code || !strings.Contains(w.Body.String(), tc.body) {
+			t.Errorf("%q: got %d %q, want %d %q", tc.query, w.Code, w.Body.String(), tc.code, tc.body)
+		}
+	}
+}
+
+func TestHandlerTargetFailure(t *testing.T) {
+	h := newZfsHandler(t)
+	// The collector is disabled once the handler serves it, the filtered
+	// target can't be created anymore.
+	setCollectorFlags(t, "--no-collector.zfs")
+	if w := scrape(h, "collect[]=zfs"); w.Code != http.StatusInternalServerError {
+		t.Errorf("got %d %q, want %d", w.Code, w.Body.String(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/version"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+
+	"github.com/microyahoo/fs_exporter/collector"
+)
+
+// secretFlag matches the names of the flags whose value must not be shown.
+var secretFlag = regexp.MustCompile(`(?i)(password|secret|token|credential|api-?key|header)`)
+
+var landingTemplate = template.Must(template.New("landing").Funcs(template.FuncMap{
+	"ago": func(t *time.Time) string { return time.Since(*t).Truncate(time.Millisecond).String() },
+}).Parse(`<html>
+<head><title>File system Exporter</title></head>
+<body>
+<h1>File system Exporter</h1>
+<p>{{.Version}}<br>{{.BuildContext}}</p>
+<ul>
+<li><a href="{{.MetricsPath}}">Metrics</a></li>
+<li><a href="/-/healthy">Health</a></li>
+<li><a href="/-/ready">Readiness</a></li>
+<li><a href="/api/v1/collectors">Collector status</a></li>
+{{- if .Pprof}}
+<li><a href="/debug/pprof/">Profiling</a></li>
+{{- else if .DebugAddress}}
+<li>Profiling is served on {{.DebugAddress}}</li>
+{{- end}}
+</ul>
+<h2>Collectors</h2>
+<table border="1" cellpadding="4">
+<tr><th>Name</th><th>Enabled</th><th>Background</th><th>Last run</th><th>Duration (s)</th><th>Breaker</th><th>Last error</th></tr>
+{{- range .Collectors}}
+<tr><td>{{.Name}}</td><td>{{.Enabled}}</td><td>{{.Background}}</td>
+{{- if .LastRun}}<td>{{ago .LastRun}} ago</td><td>{{printf "%.3f" .LastDurationSeconds}}</td>{{else}}<td>never</td><td></td>{{end}}
+<td>{{.Breaker}}{{if .BreakerNextRetry}}, retry at {{.BreakerNextRetry.Format "15:04:05"}}{{end}}</td><td>{{.LastError}}</td></tr>
+{{- end}}
+</table>
+<h2>Flags</h2>
+<table border="1" cellpadding="4">
+<tr><th>Flag</th><th>Value</th></tr>
+{{- range .Flags}}
+<tr><td>--{{.Name}}</td><td>{{if .Changed}}<b>{{.Value}}</b>{{else}}{{.Value}}{{end}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+type landingFlag struct {
+	Name    string
+	Value   string
+	Changed bool
+}
+
+// landingHandler serves the landing page with the build information, the
+// state of the collectors and the flags, the ones set explicitly in bold.
+func (o *fsExporterOptions) landingHandler(logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		data := struct {
+			Version      string
+			BuildContext string
+			MetricsPath  string
+			Pprof        bool
+			DebugAddress string
+			Collectors   []collector.Status
+			Flags        []landingFlag
+		}{
+			Version:      "Version " + version.Version + " (branch: " + version.Branch + ", revision: " + version.Revision + ")",
+			BuildContext: "Build context " + version.BuildContext(),
+			MetricsPath:  o.metricsPath,
+			Pprof:        o.enablePprof && o.debugAddress == "",
+			Collectors:   collector.Statuses(),
+		}
+		if o.enablePprof {
+			data.DebugAddress = o.debugAddress
+		}
+		if o.flags != nil {
+			o.flags.VisitAll(func(f *pflag.Flag) {
+				data.Flags = append(data.Flags, landingFlag{
+					Name:    f.Name,
+					Value:   redactFlagValue(f),
+					Changed: f.Changed,
+				})
+			})
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := landingTemplate.Execute(w, data); err != nil {
+			logger.Warn("failed to render the landing page", zap.Error(err))
+		}
+	}
+}
+
+// redactFlagValue returns the value of the flag, with secrets and the
+// passwords of URLs replaced, in each element of the slice flags.
+func redactFlagValue(f *pflag.Flag) string {
+	value := f.Value.String()
+	if value == "" || value == "[]" {
+		return value
+	}
+	if secretFlag.MatchString(f.Name) {
+		return "<secret>"
+	}
+	if s, ok := f.Value.(pflag.SliceValue); ok {
+		values := s.GetSlice()
+		for i, v := range values {
+			values[i] = redactPassword(v)
+		}
+		return "[" + strings.Join(values, ",") + "]"
+	}
+	return redactPassword(value)
+}
+
+// redactPassword replaces the password of the value if it is a URL.
+func redactPassword(value string) string {
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			return u.Redacted()
+		}
+	}
+	return value
+}
@@ -0,0 +1,199 @@
+package cmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/version"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+
+	"github.com/microyahoo/fs_exporter/collector"
+)
+
+// otlpOptions defines the export of the metrics with OTLP/HTTP.
+type otlpOptions struct {
+	endpoint    string
+	interval    time.Duration
+	headers     []string
+	compression string
+	client      clientOptions
+}
+
+func (o *otlpOptions) addFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&o.endpoint, "otlp.endpoint", "",
+		"URL to export the metrics to with OTLP/HTTP, e.g. http://localhost:4318/v1/metrics. The export is disabled if empty.")
+	flags.DurationVar(&o.interval, "otlp.interval", 30*time.Second, "Interval between the exports.")
+	flags.StringArrayVar(&o.headers, "otlp.header", nil, "Header added to the export requests, as name=value. Repeat it for more headers.")
+	flags.StringVar(&o.compression, "otlp.compression", "gzip", "Compression of the export requests, gzip or none.")
+	o.client.addFlags(flags, "otlp",
+		"Timeout of an export, including its retries.")
+}
+
+// otlpExporter exports the metrics of the collectors to an OpenTelemetry
+// Collector, or any other OTLP/HTTP receiver.
+type otlpExporter struct {
+	opts    *otlpOptions
+	headers http.Header
+	client  *http.Client
+	encoder *otlpEncoder
+	target  *scrapeTarget
+	logger  *zap.Logger
+}
+
+func (h *handler) newOTLPExporter(opts *otlpOptions, logger *zap.Logger) (*otlpExporter, error) {
+	if opts.interval <= 0 {
+		return nil, fmt.Errorf("Invalid --otlp.interval %s", opts.interval)
+	}
+	if opts.compression != "gzip" && opts.compression != "none" {
+		return nil, fmt.Errorf("Invalid --otlp.compression %q, expected gzip or none", opts.compression)
+	}
+	headers := http.Header{}
+	for _, header := range opts.headers {
+		kv := strings.SplitN(header, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("Invalid --otlp.header %q, expected name=value", header)
+		}
+		headers.Add(kv[0], kv[1])
+	}
+	client, err := opts.client.newClient()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create the OTLP client: %s", err)
+	}
+	return &otlpExporter{
+		opts:    opts,
+		headers: headers,
+		client:  client,
+		encoder: &otlpEncoder{
+			resource:    otlpResource(collector.ReadHostIdentity(logger), h.constLabels),
+			skip:        h.constLabels,
+			startTimeNs: uint64(time.Now().UnixNano()),
+		},
+		target: h.unfiltered,
+		logger: logger.With(zap.String("sender", "otlp"), zap.String("url", redactURL(opts.endpoint))),
+	}, nil
+}
+
+// otlpResource returns the resource attributes, following the semantic
+// conventions for the host and the service, and the constant labels.
+func otlpResource(host collector.HostIdentity, constLabels map[string]string) []label {
+	attrs := map[string]string{
+		"service.name":    "fs_exporter",
+		"service.version": version.Version,
+		"host.name":       host.Hostname,
+		"host.id":         host.MachineID,
+		"os.type":         runtime.GOOS,
+		"os.name":         host.OSRelease["NAME"],
+		"os.version":      host.OSRelease["VERSION_ID"],
+		"os.description":  host.OSRelease["PRETTY_NAME"],
+	}
+	for k, v := range constLabels {
+		attrs[k] = v
+	}
+	resource := make([]label, 0, len(attrs))
+	for k, v := range attrs {
+		if v != "" {
+			resource = append(resource, label{k, v})
+		}
+	}
+	sort.Slice(resource, func(i, j int) bool { return resource[i].name < resource[j].name })
+	return resource
+}
+
+func (e *otlpExporter) run(ctx context.Context) {
+	every(ctx, e.opts.interval, e.export)
+}
+
+func (e *otlpExporter) close(context.Context) {}
+
+// export gathers and exports the metrics, retrying with a backoff within
+// --otlp.timeout while the receiver is unavailable.
+func (e *otlpExporter) export(ctx context.Context) {
+	mfs := gather(ctx, e.target, e.logger)
+	ctx, cancel := context.WithTimeout(ctx, e.opts.client.timeout)
+	defer cancel()
+	body, err := e.compress(e.encoder.encode(mfs, uint64(time.Now().UnixNano())))
+	if err != nil {
+		e.logger.Warn("failed to compress the metrics", zap.Error(err))
+		return
+	}
+
+	backoff := time.Second
+	for {
+		err := e.post(ctx, body)
+		if err == nil {
+			e.logger.Debug("exported the metrics")
+			return
+		}
+		var rerr *recoverableError
+		if !errors.As(err, &rerr) {
+			e.logger.Warn("failed to export the metrics", zap.Error(err))
+			return
+		}
+		select {
+		case <-ctx.Done():
+			e.logger.Warn("failed to export the metrics", zap.Error(err))
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (e *otlpExporter) compress(body []byte) ([]byte, error) {
+	if e.opts.compression != "gzip" {
+		return body, nil
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(body); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (e *otlpExporter) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.opts.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range e.headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "fs_exporter/"+version.Version)
+	if e.opts.compression == "gzip" {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return &recoverableError{err}
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	err = fmt.Errorf("server returned HTTP status %s", resp.Status)
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return &recoverableError{err}
+	}
+	return err
+}
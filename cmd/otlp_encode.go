@@ -0,0 +1,191 @@
+package cmd
+
+import (
+	"math"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/version"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// otlpScope is the name of the instrumentation scope of the metrics.
+const otlpScope = "github.com/microyahoo/fs_exporter"
+
+// aggregationTemporalityCumulative is AGGREGATION_TEMPORALITY_CUMULATIVE.
+const aggregationTemporalityCumulative = 2
+
+// otlpEncoder encodes the metric families into the protobuf of an OTLP
+// ExportMetricsServiceRequest, see opentelemetry/proto/metrics/v1/metrics.proto.
+type otlpEncoder struct {
+	// resource are the attributes of the resource.
+	resource []label
+	// skip are the labels moved to the resource, they are removed from the
+	// attributes of the data points.
+	skip map[string]string
+	// startTimeNs is the start of the cumulative sums and histograms.
+	startTimeNs uint64
+}
+
+// encode converts the counters into monotonic cumulative sums, the gauges and
+// untyped metrics into gauges, the histograms into cumulative histograms with
+// explicit bounds and the summaries into summaries.
+func (e *otlpEncoder) encode(mfs []*dto.MetricFamily, nowNs uint64) []byte {
+	var resource []byte
+	for _, l := range e.resource {
+		resource = appendMessage(resource, 1, appendKeyValue(nil, l.name, l.value))
+	}
+
+	scope := protowire.AppendTag(nil, 1, protowire.BytesType)
+	scope = protowire.AppendString(scope, otlpScope)
+	scope = protowire.AppendTag(scope, 2, protowire.BytesType)
+	scope = protowire.AppendString(scope, version.Version)
+	scopeMetrics := appendMessage(nil, 1, scope)
+	for _, mf := range mfs {
+		scopeMetrics = appendMessage(scopeMetrics, 2, e.encodeMetric(mf, nowNs))
+	}
+
+	resourceMetrics := appendMessage(nil, 1, resource)
+	resourceMetrics = appendMessage(resourceMetrics, 2, scopeMetrics)
+	return appendMessage(nil, 1, resourceMetrics)
+}
+
+func (e *otlpEncoder) encodeMetric(mf *dto.MetricFamily, nowNs uint64) []byte {
+	b := protowire.AppendTag(nil, 1, protowire.BytesType)
+	b = protowire.AppendString(b, mf.GetName())
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendString(b, mf.GetHelp())
+
+	var data []byte
+	switch mf.GetType() {
+	case dto.MetricType_COUNTER:
+		for _, m := range mf.Metric {
+			data = appendMessage(data, 1, e.numberDataPoint(m, m.GetCounter().GetValue(), true, nowNs))
+		}
+		data = appendVarint(data, 2, aggregationTemporalityCumulative)
+		data = appendVarint(data, 3, 1)
+		return appendMessage(b, 7, data)
+	case dto.MetricType_HISTOGRAM:
+		for _, m := range mf.Metric {
+			data = appendMessage(data, 1, e.histogramDataPoint(m, nowNs))
+		}
+		data = appendVarint(data, 2, aggregationTemporalityCumulative)
+		return appendMessage(b, 9, data)
+	case dto.MetricType_SUMMARY:
+		for _, m := range mf.Metric {
+			data = appendMessage(data, 1, e.summaryDataPoint(m, nowNs))
+		}
+		return appendMessage(b, 11, data)
+	default:
+		for _, m := range mf.Metric {
+			v := m.GetGauge().GetValue()
+			if mf.GetType() == dto.MetricType_UNTYPED {
+				v = m.GetUntyped().GetValue()
+			}
+			data = appendMessage(data, 1, e.numberDataPoint(m, v, false, nowNs))
+		}
+		return appendMessage(b, 5, data)
+	}
+}
+
+// NumberDataPoint { attributes = 7; start_time_unix_nano = 2; time_unix_nano = 3; as_double = 4; }
+func (e *otlpEncoder) numberDataPoint(m *dto.Metric, v float64, cumulative bool, nowNs uint64) []byte {
+	b := e.appendAttributes(nil, 7, m)
+	if cumulative {
+		b = appendFixed64(b, 2, e.startTimeNs)
+	}
+	b = appendFixed64(b, 3, timeNs(m, nowNs))
+	return appendDouble(b, 4, v)
+}
+
+// HistogramDataPoint { attributes = 9; start_time_unix_nano = 2; time_unix_nano = 3;
+// count = 4; sum = 5; bucket_counts = 6; explicit_bounds = 7; }
+func (e *otlpEncoder) histogramDataPoint(m *dto.Metric, nowNs uint64) []byte {
+	h := m.GetHistogram()
+	b := e.appendAttributes(nil, 9, m)
+	b = appendFixed64(b, 2, e.startTimeNs)
+	b = appendFixed64(b, 3, timeNs(m, nowNs))
+	b = appendFixed64(b, 4, h.GetSampleCount())
+	b = appendDouble(b, 5, h.GetSampleSum())
+
+	// The buckets of OTLP aren't cumulative, and the last one is implicit.
+	var counts, bounds []byte
+	var previous uint64
+	for _, bucket := range h.Bucket {
+		if math.IsInf(bucket.GetUpperBound(), +1) {
+			break
+		}
+		counts = protowire.AppendFixed64(counts, bucket.GetCumulativeCount()-previous)
+		bounds = protowire.AppendFixed64(bounds, math.Float64bits(bucket.GetUpperBound()))
+		previous = bucket.GetCumulativeCount()
+	}
+	counts = protowire.AppendFixed64(counts, h.GetSampleCount()-previous)
+	b = appendMessage(b, 6, counts)
+	if len(bounds) > 0 {
+		b = appendMessage(b, 7, bounds)
+	}
+	return b
+}
+
+// SummaryDataPoint { attributes = 7; start_time_unix_nano = 2; time_unix_nano = 3;
+// count = 4; sum = 5; quantile_values = 6; }
+func (e *otlpEncoder) summaryDataPoint(m *dto.Metric, nowNs uint64) []byte {
+	s := m.GetSummary()
+	b := e.appendAttributes(nil, 7, m)
+	b = appendFixed64(b, 2, e.startTimeNs)
+	b = appendFixed64(b, 3, timeNs(m, nowNs))
+	b = appendFixed64(b, 4, s.GetSampleCount())
+	b = appendDouble(b, 5, s.GetSampleSum())
+	for _, q := range s.Quantile {
+		// ValueAtQuantile { quantile = 1; value = 2; }
+		qv := appendDouble(nil, 1, q.GetQuantile())
+		qv = appendDouble(qv, 2, q.GetValue())
+		b = appendMessage(b, 6, qv)
+	}
+	return b
+}
+
+// appendAttributes appends the labels of the metric, except the ones of the resource.
+func (e *otlpEncoder) appendAttributes(b []byte, num protowire.Number, m *dto.Metric) []byte {
+	for _, lp := range m.Label {
+		if v, ok := e.skip[lp.GetName()]; ok && v == lp.GetValue() {
+			continue
+		}
+		b = appendMessage(b, num, appendKeyValue(nil, lp.GetName(), lp.GetValue()))
+	}
+	return b
+}
+
+func timeNs(m *dto.Metric, nowNs uint64) uint64 {
+	if m.TimestampMs != nil {
+		return uint64(m.GetTimestampMs()) * 1e6
+	}
+	return nowNs
+}
+
+// appendKeyValue appends KeyValue { key = 1; AnyValue value = 2 { string_value = 1; } }.
+func appendKeyValue(b []byte, key, value string) []byte {
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, key)
+	v := protowire.AppendTag(nil, 1, protowire.BytesType)
+	v = protowire.AppendString(v, value)
+	return appendMessage(b, 2, v)
+}
+
+func appendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendFixed64(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, v)
+}
+
+func appendDouble(b []byte, num protowire.Number, v float64) []byte {
+	return appendFixed64(b, num, math.Float64bits(v))
+}
@@ -0,0 +1,218 @@
+package cmd
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// otlpPoint is a decoded data point, the counts and bounds are the ones of the histograms.
+type otlpPoint struct {
+	attributes  []label
+	startTimeNs uint64
+	timeNs      uint64
+	value       float64
+	count       uint64
+	sum         float64
+	counts      []uint64
+	bounds      []float64
+}
+
+// otlpMetric is a decoded metric, kind is the field number of its data.
+type otlpMetric struct {
+	name, help  string
+	kind        protowire.Number
+	temporality uint64
+	monotonic   bool
+	points      []otlpPoint
+}
+
+// decodeOTLP decodes the resource attributes and the metrics of an
+// ExportMetricsServiceRequest with a single resource and scope.
+func decodeOTLP(t *testing.T, b []byte) ([]label, []otlpMetric) {
+	t.Helper()
+	var (
+		resource []label
+		metrics  []otlpMetric
+	)
+	resourceMetrics := decodeFields(t, b, 1)
+	if len(resourceMetrics) != 1 {
+		t.Fatalf("got %d resources, want 1", len(resourceMetrics))
+	}
+	for _, f := range decodeMessage(t, resourceMetrics[0]) {
+		switch f.num {
+		case 1:
+			for _, attr := range decodeFields(t, f.bytes, 1) {
+				resource = append(resource, decodeKeyValue(t, attr))
+			}
+		case 2:
+			for _, sf := range decodeMessage(t, f.bytes) {
+				if sf.num == 2 {
+					metrics = append(metrics, decodeOTLPMetric(t, sf.bytes))
+				}
+			}
+		}
+	}
+	return resource, metrics
+}
+
+func decodeOTLPMetric(t *testing.T, b []byte) otlpMetric {
+	t.Helper()
+	var m otlpMetric
+	for _, f := range decodeMessage(t, b) {
+		switch f.num {
+		case 1:
+			m.name = string(f.bytes)
+		case 2:
+			m.help = string(f.bytes)
+		default:
+			m.kind = f.num
+			for _, df := range decodeMessage(t, f.bytes) {
+				switch df.num {
+				case 1:
+					m.points = append(m.points, decodeOTLPPoint(t, m.kind, df.bytes))
+				case 2:
+					m.temporality = df.varint
+				case 3:
+					m.monotonic = df.varint == 1
+				}
+			}
+		}
+	}
+	return m
+}
+
+func decodeOTLPPoint(t *testing.T, kind protowire.Number, b []byte) otlpPoint {
+	t.Helper()
+	// The attributes are field 9 of the histogram data points, 7 of the others.
+	attributes := protowire.Number(7)
+	if kind == 9 {
+		attributes = 9
+	}
+	var p otlpPoint
+	for _, f := range decodeMessage(t, b) {
+		switch {
+		case f.num == attributes:
+			p.attributes = append(p.attributes, decodeKeyValue(t, f.bytes))
+		case f.num == 2:
+			p.startTimeNs = f.varint
+		case f.num == 3:
+			p.timeNs = f.varint
+		case f.num == 4 && kind == 9:
+			p.count = f.varint
+		case f.num == 4:
+			p.value = math.Float64frombits(f.varint)
+		case f.num == 5:
+			p.sum = math.Float64frombits(f.varint)
+		case f.num == 6:
+			for i := 0; i < len(f.bytes); i += 8 {
+				p.counts = append(p.counts, binary.LittleEndian.Uint64(f.bytes[i:]))
+			}
+		case f.num == 7 && kind == 9:
+			for i := 0; i < len(f.bytes); i += 8 {
+				p.bounds = append(p.bounds, math.Float64frombits(binary.LittleEndian.Uint64(f.bytes[i:])))
+			}
+		}
+	}
+	return p
+}
+
+func decodeKeyValue(t *testing.T, b []byte) label {
+	t.Helper()
+	var l label
+	for _, f := range decodeMessage(t, b) {
+		if f.num == 1 {
+			l.name = string(f.bytes)
+		} else {
+			l.value = string(decodeFields(t, f.bytes, 1)[0])
+		}
+	}
+	return l
+}
+
+func TestOTLPEncoder(t *testing.T) {
+	const (
+		startNs = 1000
+		nowNs   = 5000000000
+	)
+	e := &otlpEncoder{
+		resource:    []label{{"host.name", "host1"}, {"site", "paris"}},
+		skip:        map[string]string{"site": "paris"},
+		startTimeNs: startNs,
+	}
+	mfs := []*dto.MetricFamily{
+		{
+			Name: proto.String("fs_used_bytes"),
+			Help: proto.String("Used bytes."),
+			Type: dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{
+				// The const labels are moved to the resource.
+				{Label: []*dto.LabelPair{labelPair("site", "paris"), labelPair("volume", "data")}, Gauge: &dto.Gauge{Value: proto.Float64(42)}},
+				// Unless the metric has its own value.
+				{Label: []*dto.LabelPair{labelPair("site", "lyon")}, Gauge: &dto.Gauge{Value: proto.Float64(-1)}, TimestampMs: proto.Int64(2000)},
+			},
+		},
+		{
+			Name:   proto.String("fs_errors_total"),
+			Help:   proto.String("Errors."),
+			Type:   dto.MetricType_COUNTER.Enum(),
+			Metric: []*dto.Metric{{Counter: &dto.Counter{Value: proto.Float64(3)}}},
+		},
+		{
+			Name: proto.String("fs_latency_seconds"),
+			Help: proto.String("Latency."),
+			Type: dto.MetricType_HISTOGRAM.Enum(),
+			Metric: []*dto.Metric{{
+				Label: []*dto.LabelPair{labelPair("volume", "data")},
+				Histogram: &dto.Histogram{
+					SampleCount: proto.Uint64(10),
+					SampleSum:   proto.Float64(2.5),
+					Bucket: []*dto.Bucket{
+						{UpperBound: proto.Float64(0.1), CumulativeCount: proto.Uint64(1)},
+						{UpperBound: proto.Float64(1), CumulativeCount: proto.Uint64(4)},
+						{UpperBound: proto.Float64(math.Inf(+1)), CumulativeCount: proto.Uint64(10)},
+					},
+				},
+			}},
+		},
+	}
+
+	resource, metrics := decodeOTLP(t, e.encode(mfs, nowNs))
+	if !reflect.DeepEqual(resource, e.resource) {
+		t.Errorf("got resource %v, want %v", resource, e.resource)
+	}
+	want := []otlpMetric{
+		{
+			name: "fs_used_bytes", help: "Used bytes.", kind: 5,
+			points: []otlpPoint{
+				{attributes: []label{{"volume", "data"}}, timeNs: nowNs, value: 42},
+				{attributes: []label{{"site", "lyon"}}, timeNs: 2000 * 1e6, value: -1},
+			},
+		},
+		{
+			name: "fs_errors_total", help: "Errors.", kind: 7, temporality: aggregationTemporalityCumulative, monotonic: true,
+			points: []otlpPoint{{startTimeNs: startNs, timeNs: nowNs, value: 3}},
+		},
+		{
+			name: "fs_latency_seconds", help: "Latency.", kind: 9, temporality: aggregationTemporalityCumulative,
+			points: []otlpPoint{{
+				attributes:  []label{{"volume", "data"}},
+				startTimeNs: startNs,
+				timeNs:      nowNs,
+				count:       10,
+				sum:         2.5,
+				// The bucket counts aren't cumulative, the +Inf bucket is implicit.
+				counts: []uint64{1, 3, 6},
+				bounds: []float64{0.1, 1},
+			}},
+		},
+	}
+	if !reflect.DeepEqual(metrics, want) {
+		t.Errorf("got metrics\n%+v\nwant\n%+v", metrics, want)
+	}
+}
@@ -2,8 +2,9 @@ package cmd
 
 import (
 	"fmt"
+	"net/http"
+	httppprof "net/http/pprof"
 	"os"
-	"os/signal"
 	"runtime"
 	"runtime/pprof"
 
@@ -46,16 +47,6 @@ func initProfiling() error {
 		}
 	}
 
-	// If the command is interrupted before the end (ctrl-c), flush the
-	// profiling files
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	go func() {
-		<-c
-		flushProfiling()
-		os.Exit(0)
-	}()
-
 	return nil
 }
 
@@ -82,3 +73,12 @@ func flushProfiling() error {
 
 	return nil
 }
+
+// registerPprofHandlers serves the runtime profiling data under /debug/pprof/ on the mux.
+func registerPprofHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", httppprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", httppprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", httppprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", httppprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", httppprof.Trace)
+}
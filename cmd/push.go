@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+)
+
+// pushOptions defines the push of the metrics to a Pushgateway.
+type pushOptions struct {
+	url              string
+	job              string
+	instance         string
+	interval         time.Duration
+	deleteOnShutdown bool
+	client           clientOptions
+}
+
+func (p *pushOptions) addFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&p.url, "push.url", "", "URL of the Pushgateway to push the metrics to, the push is disabled if empty.")
+	flags.StringVar(&p.job, "push.job", "fs_exporter", "Job label of the pushed group.")
+	flags.StringVar(&p.instance, "push.instance", "", "Instance label of the pushed group (default the hostname).")
+	flags.DurationVar(&p.interval, "push.interval", time.Minute, "Interval between the pushes.")
+	flags.BoolVar(&p.deleteOnShutdown, "push.delete-on-shutdown", true, "Delete the pushed group from the Pushgateway on shutdown.")
+	p.client.addFlags(flags, "push", "Timeout of the requests.")
+}
+
+// pusher pushes the metrics of the collectors to a Pushgateway, the group
+// of its job and instance is replaced on every push.
+type pusher struct {
+	opts     *pushOptions
+	instance string
+	client   *http.Client
+	target   *scrapeTarget
+	logger   *zap.Logger
+}
+
+func (h *handler) newPusher(opts *pushOptions, logger *zap.Logger) (*pusher, error) {
+	if opts.interval <= 0 {
+		return nil, fmt.Errorf("Invalid --push.interval %s", opts.interval)
+	}
+	client, err := opts.client.newClient()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create the push client: %s", err)
+	}
+	instance := opts.instance
+	if instance == "" {
+		if instance, err = os.Hostname(); err != nil {
+			return nil, fmt.Errorf("Failed to get the hostname for the push instance: %s", err)
+		}
+	}
+	return &pusher{
+		opts:     opts,
+		instance: instance,
+		client:   client,
+		target:   h.unfiltered,
+		logger:   logger.With(zap.String("sender", "push"), zap.String("url", redactURL(opts.url))),
+	}, nil
+}
+
+// newPusher returns a pusher whose requests are bound to ctx.
+func (p *pusher) newPusher(ctx context.Context) *push.Pusher {
+	return push.New(p.opts.url, p.opts.job).
+		Grouping("instance", p.instance).
+		Client(&contextDoer{ctx: ctx, client: p.client})
+}
+
+func (p *pusher) run(ctx context.Context) {
+	every(ctx, p.opts.interval, p.push)
+}
+
+// push gathers the metrics and pushes them within --push.timeout.
+func (p *pusher) push(ctx context.Context) {
+	mfs := gather(ctx, p.target, p.logger)
+	ctx, cancel := context.WithTimeout(ctx, p.opts.client.timeout)
+	defer cancel()
+	err := p.newPusher(ctx).
+		Gatherer(prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			return mfs, nil
+		})).
+		Push()
+	if err != nil {
+		p.logger.Warn("failed to push the metrics", zap.Error(err))
+		return
+	}
+	p.logger.Debug("pushed the metrics")
+}
+
+func (p *pusher) close(ctx context.Context) {
+	if !p.opts.deleteOnShutdown {
+		return
+	}
+	if err := p.newPusher(ctx).Delete(); err != nil {
+		p.logger.Warn("failed to delete the pushed group", zap.Error(err))
+		return
+	}
+	p.logger.Info("deleted the pushed group")
+}
+
+// contextDoer binds the requests of the pusher to ctx.
+type contextDoer struct {
+	ctx    context.Context
+	client *http.Client
+}
+
+func (d *contextDoer) Do(req *http.Request) (*http.Response, error) {
+	return d.client.Do(req.WithContext(d.ctx))
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// pushRequest is a request received by the test Pushgateway.
+type pushRequest struct {
+	method, path   string
+	user, password string
+	body           string
+}
+
+func TestPusher(t *testing.T) {
+	var (
+		mtx      sync.Mutex
+		requests []pushRequest
+	)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		user, password, _ := r.BasicAuth()
+		mtx.Lock()
+		requests = append(requests, pushRequest{r.Method, r.URL.Path, user, password, string(body)})
+		mtx.Unlock()
+		// The Pushgateway accepts the deletions with a 202.
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer server.Close()
+
+	opts := &pushOptions{
+		url:              server.URL,
+		job:              "fs",
+		instance:         "host1",
+		interval:         time.Minute,
+		deleteOnShutdown: true,
+		client: clientOptions{
+			timeout:      time.Second,
+			username:     "alice",
+			passwordFile: writeFile(t, "password", "secret\n"),
+			caFile:       serverCAFile(t, server),
+		},
+	}
+	p, err := newTestHandler(t, handlerOptions{}).newPusher(opts, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.push(context.Background())
+	p.close(context.Background())
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	if len(requests) != 2 {
+		t.Fatalf("got %d requests, want 2: %+v", len(requests), requests)
+	}
+	for i, want := range []pushRequest{
+		{method: http.MethodPut, path: "/metrics/job/fs/instance/host1", user: "alice", password: "secret"},
+		{method: http.MethodDelete, path: "/metrics/job/fs/instance/host1", user: "alice", password: "secret"},
+	} {
+		got := requests[i]
+		if got.method != want.method || got.path != want.path || got.user != want.user || got.password != want.password {
+			t.Errorf("got request %s %s as %s:%s, want %s %s as %s:%s", got.method, got.path, got.user, got.password,
+				want.method, want.path, want.user, want.password)
+		}
+	}
+	// The body is in the protobuf delimited format, the names are in clear.
+	if !strings.Contains(requests[0].body, "fs_scrape_coalesced_total") {
+		t.Errorf("got push body without the exporter metrics: %q", requests[0].body)
+	}
+}
+
+func TestPusherUntrustedServer(t *testing.T) {
+	requests := 0
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	// The rejected handshake is expected.
+	server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	opts := &pushOptions{url: server.URL, job: "fs", instance: "host1", interval: time.Minute,
+		client: clientOptions{timeout: time.Second}}
+	p, err := newTestHandler(t, handlerOptions{}).newPusher(opts, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.push(context.Background())
+	if requests != 0 {
+		t.Errorf("got %d requests with an untrusted certificate, want 0", requests)
+	}
+}
+
+func TestRedactURL(t *testing.T) {
+	for _, tc := range []struct{ url, want string }{
+		{"https://user:pw@host:9091/path", "https://host:9091/path"},
+		{"https://user@host/", "https://host/"},
+		{"http://host/api/v1/write", "http://host/api/v1/write"},
+	} {
+		if got := redactURL(tc.url); got != tc.want {
+			t.Errorf("redactURL(%q) = %q, want %q", tc.url, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/common/version"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+)
+
+// remoteWriteOptions defines the remote_write of the metrics.
+type remoteWriteOptions struct {
+	urls              []string
+	interval          time.Duration
+	job               string
+	instance          string
+	maxSamplesPerSend int
+	queueCapacity     int
+	minBackoff        time.Duration
+	maxBackoff        time.Duration
+	client            clientOptions
+}
+
+func (r *remoteWriteOptions) addFlags(flags *pflag.FlagSet) {
+	flags.StringArrayVar(&r.urls, "remote-write.url", nil,
+		"URL of a remote_write endpoint to send the metrics to. Repeat it for more endpoints, remote_write is disabled if empty.")
+	flags.DurationVar(&r.interval, "remote-write.interval", 30*time.Second, "Interval between the gatherings of the metrics sent.")
+	flags.StringVar(&r.job, "remote-write.job", "fs_exporter", "Job label added to the series sent.")
+	flags.StringVar(&r.instance, "remote-write.instance", "", "Instance label added to the series sent (default the hostname).")
+	flags.IntVar(&r.maxSamplesPerSend, "remote-write.max-samples-per-send", 2000, "Maximum number of samples per request.")
+	flags.IntVar(&r.queueCapacity, "remote-write.queue-capacity", 20000,
+		"Maximum number of samples queued per endpoint, the oldest ones are dropped when it is full.")
+	flags.DurationVar(&r.minBackoff, "remote-write.min-backoff", 100*time.Millisecond, "Initial delay before retrying a failed request.")
+	flags.DurationVar(&r.maxBackoff, "remote-write.max-backoff", 30*time.Second, "Maximum delay before retrying a failed request.")
+	r.client.addFlags(flags, "remote-write", "Timeout of the requests.")
+}
+
+var (
+	remoteWriteSamplesSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "remote_write",
+		Name:      "samples_sent_total",
+		Help:      "Total number of samples sent to the remote_write endpoint.",
+	}, []string{"url"})
+	remoteWriteSamplesFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "remote_write",
+		Name:      "samples_failed_total",
+		Help:      "Total number of samples which couldn't be sent to the remote_write endpoint.",
+	}, []string{"url"})
+	remoteWriteSamplesDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "remote_write",
+		Name:      "samples_dropped_total",
+		Help:      "Total number of samples dropped because the queue of the remote_write endpoint was full.",
+	}, []string{"url"})
+	remoteWriteRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "remote_write",
+		Name:      "retries_total",
+		Help:      "Total number of retried requests to the remote_write endpoint.",
+	}, []string{"url"})
+	remoteWriteQueuedSamples = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "remote_write",
+		Name:      "queued_samples",
+		Help:      "Number of samples waiting to be sent to the remote_write endpoint.",
+	}, []string{"url"})
+)
+
+// remoteWriter gathers the metrics of the collectors on an interval, and
+// sends them to the remote_write endpoints. Each endpoint has its own queue,
+// so that a slow endpoint doesn't hold back the others.
+type remoteWriter struct {
+	opts      *remoteWriteOptions
+	extra     []label
+	target    *scrapeTarget
+	endpoints []*remoteWriteEndpoint
+	logger    *zap.Logger
+}
+
+func (h *handler) newRemoteWriter(opts *remoteWriteOptions, logger *zap.Logger) (*remoteWriter, error) {
+	if opts.interval <= 0 {
+		return nil, fmt.Errorf("Invalid --remote-write.interval %s", opts.interval)
+	}
+	if opts.maxSamplesPerSend <= 0 || opts.queueCapacity < opts.maxSamplesPerSend {
+		return nil, fmt.Errorf("Invalid --remote-write.queue-capacity %d, it must be at least --remote-write.max-samples-per-send %d",
+			opts.queueCapacity, opts.maxSamplesPerSend)
+	}
+	client, err := opts.client.newClient()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create the remote_write client: %s", err)
+	}
+	instance := opts.instance
+	if instance == "" {
+		if instance, err = os.Hostname(); err != nil {
+			return nil, fmt.Errorf("Failed to get the hostname for the remote_write instance: %s", err)
+		}
+	}
+	for _, c := range []prometheus.Collector{remoteWriteSamplesSent, remoteWriteSamplesFailed,
+		remoteWriteSamplesDropped, remoteWriteRetries, remoteWriteQueuedSamples} {
+		if err := h.exporterMetricsRegistry.Register(c); err != nil {
+			return nil, fmt.Errorf("Couldn't register remote_write metrics: %s", err)
+		}
+	}
+	w := &remoteWriter{
+		opts:   opts,
+		extra:  []label{{model.JobLabel, opts.job}, {model.InstanceLabel, instance}},
+		target: h.unfiltered,
+		logger: logger.With(zap.String("sender", "remote-write")),
+	}
+	for _, url := range opts.urls {
+		// The credentials of the URL must not end up in the logs and labels.
+		redacted := redactURL(url)
+		w.endpoints = append(w.endpoints, &remoteWriteEndpoint{
+			opts:    opts,
+			url:     url,
+			client:  client,
+			queue:   newWriteQueue(opts.queueCapacity),
+			logger:  w.logger.With(zap.String("url", redacted)),
+			sent:    remoteWriteSamplesSent.WithLabelValues(redacted),
+			failed:  remoteWriteSamplesFailed.WithLabelValues(redacted),
+			dropped: remoteWriteSamplesDropped.WithLabelValues(redacted),
+			retries: remoteWriteRetries.WithLabelValues(redacted),
+			queued:  remoteWriteQueuedSamples.WithLabelValues(redacted),
+		})
+	}
+	return w, nil
+}
+
+func (w *remoteWriter) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, e := range w.endpoints {
+		wg.Add(1)
+		go func(e *remoteWriteEndpoint) {
+			defer wg.Done()
+			e.run(ctx)
+		}(e)
+	}
+	every(ctx, w.opts.interval, w.gather)
+	wg.Wait()
+}
+
+// gather gathers the metrics and queues them in batches on every endpoint.
+func (w *remoteWriter) gather(ctx context.Context) {
+	now := time.Now()
+	mfs := gather(ctx, w.target, w.logger)
+	series := toTimeSeries(mfs, w.extra, now.UnixNano()/int64(time.Millisecond))
+	for len(series) > 0 {
+		n := w.opts.maxSamplesPerSend
+		if n > len(series) {
+			n = len(series)
+		}
+		for _, e := range w.endpoints {
+			e.enqueue(series[:n])
+		}
+		series = series[n:]
+	}
+}
+
+// close sends the queued samples once, without retrying.
+func (w *remoteWriter) close(ctx context.Context) {
+	for _, e := range w.endpoints {
+		for {
+			batch := e.queue.tryPop()
+			if batch == nil {
+				break
+			}
+			e.queued.Sub(float64(len(batch)))
+			if err := e.write(ctx, batch); err != nil {
+				e.failed.Add(float64(len(batch)))
+				e.logger.Warn("failed to send the queued samples on shutdown", zap.Error(err))
+				continue
+			}
+			e.sent.Add(float64(len(batch)))
+		}
+	}
+}
+
+// remoteWriteEndpoint sends the batches of its queue to a remote_write endpoint.
+type remoteWriteEndpoint struct {
+	opts   *remoteWriteOptions
+	url    string
+	client *http.Client
+	queue  *writeQueue
+	logger *zap.Logger
+
+	sent, failed, dropped, retries prometheus.Counter
+	queued                         prometheus.Gauge
+}
+
+func (e *remoteWriteEndpoint) enqueue(batch []timeSeries) {
+	dropped := e.queue.push(batch)
+	e.queued.Add(float64(len(batch) - dropped))
+	if dropped > 0 {
+		e.dropped.Add(float64(dropped))
+		e.logger.Warn("remote_write queue is full, dropped the oldest samples", zap.Int("samples", dropped))
+	}
+}
+
+func (e *remoteWriteEndpoint) run(ctx context.Context) {
+	for {
+		batch := e.queue.pop(ctx)
+		if batch == nil {
+			return
+		}
+		e.queued.Sub(float64(len(batch)))
+		e.send(ctx, batch)
+	}
+}
+
+// send sends the batch, retrying with an exponential backoff while the
+// endpoint is unavailable.
+func (e *remoteWriteEndpoint) send(ctx context.Context, batch []timeSeries) {
+	backoff := e.opts.minBackoff
+	for {
+		err := e.write(ctx, batch)
+		if err == nil {
+			e.sent.Add(float64(len(batch)))
+			return
+		}
+		var rerr *recoverableError
+		if !errors.As(err, &rerr) || ctx.Err() != nil {
+			e.failed.Add(float64(len(batch)))
+			e.logger.Warn("failed to send the samples", zap.Int("samples", len(batch)), zap.Error(err))
+			return
+		}
+		e.logger.Debug("retrying to send the samples", zap.Duration("backoff", backoff), zap.Error(err))
+		e.retries.Inc()
+		select {
+		case <-ctx.Done():
+			e.failed.Add(float64(len(batch)))
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > e.opts.maxBackoff {
+			backoff = e.opts.maxBackoff
+		}
+	}
+}
+
+// recoverableError is an error after which the request can be retried.
+type recoverableError struct {
+	error
+}
+
+func (e *remoteWriteEndpoint) write(ctx context.Context, batch []timeSeries) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(encodeWriteRequest(batch)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "fs_exporter/"+version.Version)
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return &recoverableError{err}
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("server returned HTTP status %s: %s", resp.Status, bytes.TrimSpace(body))
+	if resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests {
+		return &recoverableError{err}
+	}
+	return err
+}
+
+// writeQueue is a bounded queue of batches, the oldest batches are dropped
+// when it is full.
+type writeQueue struct {
+	mtx      sync.Mutex
+	batches  [][]timeSeries
+	samples  int
+	capacity int
+	notify   chan struct{}
+}
+
+func newWriteQueue(capacity int) *writeQueue {
+	return &writeQueue{capacity: capacity, notify: make(chan struct{}, 1)}
+}
+
+// push queues the batch, and returns the number of samples dropped for it.
+func (q *writeQueue) push(batch []timeSeries) int {
+	q.mtx.Lock()
+	dropped := 0
+	for q.samples+len(batch) > q.capacity && len(q.batches) > 0 {
+		dropped += len(q.batches[0])
+		q.samples -= len(q.batches[0])
+		q.batches = q.batches[1:]
+	}
+	q.batches = append(q.batches, batch)
+	q.samples += len(batch)
+	q.mtx.Unlock()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return dropped
+}
+
+// pop returns the oldest batch, waiting for one until ctx is done.
+func (q *writeQueue) pop(ctx context.Context) []timeSeries {
+	for {
+		if batch := q.tryPop(); batch != nil {
+			return batch
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-q.notify:
+		}
+	}
+}
+
+// tryPop returns the oldest batch, or nil if the queue is empty.
+func (q *writeQueue) tryPop() []timeSeries {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	if len(q.batches) == 0 {
+		return nil
+	}
+	batch := q.batches[0]
+	q.batches = q.batches[1:]
+	q.samples -= len(batch)
+	return batch
+}
@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/golang/snappy"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// timeSeries is a series with a single sample, as sent by remote_write.
+type timeSeries struct {
+	labels      []label // sorted by name
+	value       float64
+	timestampMs int64
+}
+
+type label struct {
+	name, value string
+}
+
+// toTimeSeries flattens the metric families into series, the extra labels
+// are added to the series which don't have them. The histograms and summaries
+// are split into their _bucket, quantile, _sum and _count series.
+func toTimeSeries(mfs []*dto.MetricFamily, extra []label, timestampMs int64) []timeSeries {
+	var series []timeSeries
+	for _, mf := range mfs {
+		name := mf.GetName()
+		for _, m := range mf.Metric {
+			ts := timestampMs
+			if m.TimestampMs != nil {
+				ts = m.GetTimestampMs()
+			}
+			add := func(suffix string, v float64, extraName, extraValue string) {
+				labels := make([]label, 0, len(m.Label)+len(extra)+2)
+				labels = append(labels, label{model.MetricNameLabel, name + suffix})
+				for _, lp := range m.Label {
+					labels = append(labels, label{lp.GetName(), lp.GetValue()})
+				}
+				if extraName != "" {
+					labels = append(labels, label{extraName, extraValue})
+				}
+				labels = withLabels(labels, extra)
+				series = append(series, timeSeries{labels: labels, value: v, timestampMs: ts})
+			}
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add("", m.GetCounter().GetValue(), "", "")
+			case dto.MetricType_GAUGE:
+				add("", m.GetGauge().GetValue(), "", "")
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.Quantile {
+					add("", q.GetValue(), model.QuantileLabel, formatFloat(q.GetQuantile()))
+				}
+				add("_sum", s.GetSampleSum(), "", "")
+				add("_count", float64(s.GetSampleCount()), "", "")
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				inf := false
+				for _, b := range h.Bucket {
+					if math.IsInf(b.GetUpperBound(), +1) {
+						inf = true
+					}
+					add("_bucket", float64(b.GetCumulativeCount()), model.BucketLabel, formatFloat(b.GetUpperBound()))
+				}
+				if !inf {
+					add("_bucket", float64(h.GetSampleCount()), model.BucketLabel, "+Inf")
+				}
+				add("_sum", h.GetSampleSum(), "", "")
+				add("_count", float64(h.GetSampleCount()), "", "")
+			default:
+				add("", m.GetUntyped().GetValue(), "", "")
+			}
+		}
+	}
+	return series
+}
+
+// withLabels adds the extra labels missing from labels, and sorts them.
+func withLabels(labels, extra []label) []label {
+	for _, e := range extra {
+		found := false
+		for _, l := range labels {
+			if l.name == e.name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			labels = append(labels, e)
+		}
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+	return labels
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// encodeWriteRequest returns the snappy-compressed protobuf encoding of the
+// prometheus.WriteRequest of the series:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(series []timeSeries) []byte {
+	var b, ts, buf []byte
+	for _, s := range series {
+		ts = ts[:0]
+		for _, l := range s.labels {
+			buf = buf[:0]
+			buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+			buf = protowire.AppendString(buf, l.name)
+			buf = protowire.AppendTag(buf, 2, protowire.BytesType)
+			buf = protowire.AppendString(buf, l.value)
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, buf)
+		}
+		buf = buf[:0]
+		buf = protowire.AppendTag(buf, 1, protowire.Fixed64Type)
+		buf = protowire.AppendFixed64(buf, math.Float64bits(s.value))
+		buf = protowire.AppendTag(buf, 2, protowire.VarintType)
+		buf = protowire.AppendVarint(buf, uint64(s.timestampMs))
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, buf)
+
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, ts)
+	}
+	return snappy.Encode(nil, b)
+}
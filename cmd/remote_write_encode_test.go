@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/golang/snappy"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// decodeWriteRequest decodes the series of a snappy-compressed prometheus.WriteRequest.
+func decodeWriteRequest(t *testing.T, body []byte) []timeSeries {
+	t.Helper()
+	b, err := snappy.Decode(nil, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var series []timeSeries
+	for _, ts := range decodeFields(t, b, 1) {
+		var s timeSeries
+		for _, f := range decodeMessage(t, ts) {
+			switch f.num {
+			case 1:
+				var l label
+				for _, lf := range decodeMessage(t, f.bytes) {
+					if lf.num == 1 {
+						l.name = string(lf.bytes)
+					} else {
+						l.value = string(lf.bytes)
+					}
+				}
+				s.labels = append(s.labels, l)
+			case 2:
+				for _, sf := range decodeMessage(t, f.bytes) {
+					if sf.num == 1 {
+						s.value = math.Float64frombits(sf.varint)
+					} else {
+						s.timestampMs = int64(sf.varint)
+					}
+				}
+			}
+		}
+		series = append(series, s)
+	}
+	return series
+}
+
+// field is a decoded protobuf field, its fixed64 and varint values are both in varint.
+type field struct {
+	num    protowire.Number
+	bytes  []byte
+	varint uint64
+}
+
+func decodeMessage(t *testing.T, b []byte) []field {
+	t.Helper()
+	var fields []field
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		b = b[n:]
+		f := field{num: num}
+		switch typ {
+		case protowire.BytesType:
+			f.bytes, n = protowire.ConsumeBytes(b)
+		case protowire.VarintType:
+			f.varint, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			f.varint, n = protowire.ConsumeFixed64(b)
+		default:
+			t.Fatalf("unexpected wire type %d of field %d", typ, num)
+		}
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		b = b[n:]
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// decodeFields returns the bytes of the num fields of the message.
+func decodeFields(t *testing.T, b []byte, num protowire.Number) [][]byte {
+	t.Helper()
+	var values [][]byte
+	for _, f := range decodeMessage(t, b) {
+		if f.num != num {
+			t.Fatalf("unexpected field %d", f.num)
+		}
+		values = append(values, f.bytes)
+	}
+	return values
+}
+
+func labelPair(name, value string) *dto.LabelPair {
+	return &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)}
+}
+
+func TestEncodeWriteRequest(t *testing.T) {
+	mfs := []*dto.MetricFamily{
+		{
+			Name: proto.String("fs_used_bytes"),
+			Type: dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{
+				// The labels of the metric win over the extra labels.
+				{Label: []*dto.LabelPair{labelPair("volume", "data"), labelPair("instance", "nas1")}, Gauge: &dto.Gauge{Value: proto.Float64(42)}},
+				// The timestamps of the metrics are kept.
+				{Label: []*dto.LabelPair{labelPair("volume", "logs")}, Gauge: &dto.Gauge{Value: proto.Float64(-1)}, TimestampMs: proto.Int64(1000)},
+			},
+		},
+		{
+			Name:   proto.String("fs_errors_total"),
+			Type:   dto.MetricType_COUNTER.Enum(),
+			Metric: []*dto.Metric{{Counter: &dto.Counter{Value: proto.Float64(3)}}},
+		},
+		{
+			Name: proto.String("fs_latency_seconds"),
+			Type: dto.MetricType_HISTOGRAM.Enum(),
+			Metric: []*dto.Metric{{Histogram: &dto.Histogram{
+				SampleCount: proto.Uint64(5),
+				SampleSum:   proto.Float64(2.5),
+				Bucket: []*dto.Bucket{
+					{UpperBound: proto.Float64(0.1), CumulativeCount: proto.Uint64(1)},
+					{UpperBound: proto.Float64(1), CumulativeCount: proto.Uint64(4)},
+				},
+			}}},
+		},
+	}
+	extra := []label{{"job", "fs_exporter"}, {"instance", "host1"}}
+	series := toTimeSeries(mfs, extra, 2000)
+
+	want := []timeSeries{
+		{[]label{{"__name__", "fs_used_bytes"}, {"instance", "nas1"}, {"job", "fs_exporter"}, {"volume", "data"}}, 42, 2000},
+		{[]label{{"__name__", "fs_used_bytes"}, {"instance", "host1"}, {"job", "fs_exporter"}, {"volume", "logs"}}, -1, 1000},
+		{[]label{{"__name__", "fs_errors_total"}, {"instance", "host1"}, {"job", "fs_exporter"}}, 3, 2000},
+		{[]label{{"__name__", "fs_latency_seconds_bucket"}, {"instance", "host1"}, {"job", "fs_exporter"}, {"le", "0.1"}}, 1, 2000},
+		{[]label{{"__name__", "fs_latency_seconds_bucket"}, {"instance", "host1"}, {"job", "fs_exporter"}, {"le", "1"}}, 4, 2000},
+		// The +Inf bucket is added when the histogram doesn't have it.
+		{[]label{{"__name__", "fs_latency_seconds_bucket"}, {"instance", "host1"}, {"job", "fs_exporter"}, {"le", "+Inf"}}, 5, 2000},
+		{[]label{{"__name__", "fs_latency_seconds_sum"}, {"instance", "host1"}, {"job", "fs_exporter"}}, 2.5, 2000},
+		{[]label{{"__name__", "fs_latency_seconds_count"}, {"instance", "host1"}, {"job", "fs_exporter"}}, 5, 2000},
+	}
+	got := decodeWriteRequest(t, encodeWriteRequest(series))
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got series\n%v\nwant\n%v", got, want)
+	}
+}
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+)
+
+// newTestRemoteWriter returns a remote writer of the url, whose queue holds capacity samples.
+func newTestRemoteWriter(t *testing.T, url string, capacity int) *remoteWriter {
+	t.Helper()
+	opts := &remoteWriteOptions{
+		urls:              []string{url},
+		interval:          time.Minute,
+		job:               "fs_exporter",
+		instance:          "host1",
+		maxSamplesPerSend: 1,
+		queueCapacity:     capacity,
+		minBackoff:        time.Millisecond,
+		maxBackoff:        4 * time.Millisecond,
+		client:            clientOptions{timeout: time.Second},
+	}
+	w, err := newTestHandler(t, handlerOptions{}).newRemoteWriter(opts, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func testSeries(values ...float64) []timeSeries {
+	var series []timeSeries
+	for _, v := range values {
+		series = append(series, timeSeries{labels: []label{{"__name__", "fs_test"}}, value: v, timestampMs: 1000})
+	}
+	return series
+}
+
+func TestRemoteWriteRetries(t *testing.T) {
+	var (
+		mtx      sync.Mutex
+		statuses = []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusNoContent}
+		bodies   [][]byte
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mtx.Lock()
+		defer mtx.Unlock()
+		if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("X-Prometheus-Remote-Write-Version") != "0.1.0" {
+			t.Errorf("got headers %v", r.Header)
+		}
+		bodies = append(bodies, body)
+		w.WriteHeader(statuses[0])
+		statuses = statuses[1:]
+	}))
+	defer server.Close()
+
+	// The user information of the URL is only used for the requests.
+	url := strings.Replace(server.URL, "http://", "http://user:pw@", 1)
+	e := newTestRemoteWriter(t, url, 10).endpoints[0]
+	e.send(context.Background(), testSeries(1, 2))
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	if len(bodies) != 3 {
+		t.Fatalf("got %d requests, want 3", len(bodies))
+	}
+	for _, body := range bodies {
+		if got := decodeWriteRequest(t, body); len(got) != 2 {
+			t.Errorf("got %d series in a retried request, want 2", len(got))
+		}
+	}
+	if got := testutil.ToFloat64(e.retries); got != 2 {
+		t.Errorf("got %v retries, want 2", got)
+	}
+	if got := testutil.ToFloat64(e.sent); got != 2 {
+		t.Errorf("got %v samples sent, want 2", got)
+	}
+	if got := testutil.ToFloat64(remoteWriteSamplesSent.WithLabelValues(server.URL)); got != 2 {
+		t.Errorf("got %v samples sent with the redacted url label, want 2", got)
+	}
+}
+
+func TestRemoteWriteDoesNotRetryClientErrors(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Error(w, "out of order sample", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	e := newTestRemoteWriter(t, server.URL, 10).endpoints[0]
+	e.send(context.Background(), testSeries(1))
+	if requests != 1 {
+		t.Errorf("got %d requests, want 1", requests)
+	}
+	if got := testutil.ToFloat64(e.failed); got != 1 {
+		t.Errorf("got %v failed samples, want 1", got)
+	}
+	if got := testutil.ToFloat64(e.retries); got != 0 {
+		t.Errorf("got %v retries, want 0", got)
+	}
+}
+
+func TestRemoteWriteQueueFull(t *testing.T) {
+	// The endpoint isn't run, nothing is sent. Its metrics outlive the test,
+	// they are reset for -count.
+	const url = "http://127.0.0.1:1/api/v1/write"
+	remoteWriteSamplesDropped.DeleteLabelValues(url)
+	remoteWriteQueuedSamples.DeleteLabelValues(url)
+	e := newTestRemoteWriter(t, url, 3).endpoints[0]
+	for i := 0; i < 5; i++ {
+		e.enqueue(testSeries(float64(i)))
+	}
+	if got := testutil.ToFloat64(e.dropped); got != 2 {
+		t.Errorf("got %v dropped samples, want 2", got)
+	}
+	if got := testutil.ToFloat64(e.queued); got != 3 {
+		t.Errorf("got %v queued samples, want 3", got)
+	}
+	// The oldest samples were dropped.
+	for want := 2.0; want < 5; want++ {
+		batch := e.queue.tryPop()
+		if len(batch) != 1 || batch[0].value != want {
+			t.Errorf("got batch %v, want the sample %v", batch, want)
+		}
+	}
+	if batch := e.queue.tryPop(); batch != nil {
+		t.Errorf("got batch %v, want an empty queue", batch)
+	}
+}
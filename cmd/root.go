@@ -4,10 +4,14 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/common/version"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 
@@ -21,12 +25,38 @@ var (
 
 // fsExporterOptions defines the options of file system
 type fsExporterOptions struct {
-	maxRequests   int64
-	listenAddress string
-	metricsPath   string
+	maxRequests     int64
+	listenAddresses []string
+	systemdSocket   bool
+	socketMode      string
+	metricsPath     string
+	timeoutOffset   time.Duration
+	webConfigFile   string
+	enablePprof     bool
+	debugAddress    string
+
+	shutdownTimeout time.Duration
+
+	// senders
+	push        pushOptions
+	remoteWrite remoteWriteOptions
+	otlp        otlpOptions
+	graphite    graphiteOptions
+	statsd      statsdOptions
+
+	// collector
+	disableDefaultCollectors bool
+	disableExporterMetrics   bool
+
+	// metrics
+	constLabels []string
+	hostInfo    bool
 
 	// log
 	logConfig *logutil.LogConfig
+
+	// flags are shown on the landing page.
+	flags *pflag.FlagSet
 }
 
 func newFSExporterOptions() *fsExporterOptions {
@@ -54,20 +84,51 @@ func NewFSExporterCommand() *cobra.Command {
 		},
 
 		Run: func(cmd *cobra.Command, args []string) {
+			o.flags = cmd.Flags()
 			cobra.CheckErr(o.Run())
 		},
 	}
 	flags := cmds.PersistentFlags()
+	flags.StringVar(&cfgFile, "config", "", "config file (default is $HOME/.fs_exporter.yaml)")
 
 	addProfilingFlags(flags)
+	collector.AddCollectorFlags(flags)
+	collector.AddExecFlags(flags)
+	collector.AddPathFlags(flags)
 	collector.AddGlusterFlags(flags)
 
 	cmds.Flags().Int64Var(&o.maxRequests, "web.max-requests", 40, "Maximum number of parallel scrape requests. Use 0 to disable.")
 	cmds.Flags().StringVar(&o.logConfig.LogLevel, "log.level", "info", "log level")
-	cmds.Flags().StringVar(&o.listenAddress, "web.listen-address", ":9097", "Address to listen on for telemetry")
+	cmds.Flags().StringArrayVar(&o.listenAddresses, "web.listen-address", []string{":9097"},
+		"Address to listen on for telemetry, or unix:///path for a Unix socket. Repeat it to listen on more addresses.")
+	cmds.Flags().BoolVar(&o.systemdSocket, "web.systemd-socket", false,
+		"Use the sockets passed by systemd socket activation instead of --web.listen-address.")
+	cmds.Flags().StringVar(&o.socketMode, "web.socket-mode", "0660", "Permissions of the Unix sockets, in octal.")
 	cmds.Flags().StringVar(&o.metricsPath, "web.telemetry-path", "/metrics", "Path under which to expose metrics")
+	cmds.Flags().StringVar(&o.webConfigFile, "web.config.file", "",
+		"Path to configuration file that can enable TLS or authentication, in the format of the Prometheus exporter-toolkit.")
+	cmds.Flags().BoolVar(&o.disableExporterMetrics, "web.disable-exporter-metrics", false,
+		"Exclude metrics about the exporter itself (promhttp_*, process_*, go_*).")
+	cmds.Flags().StringArrayVar(&o.constLabels, "metrics.const-label", nil,
+		"Constant label added to every metric of the collectors, as key=value. Repeat it for more labels, they override the metrics.const-labels of the config file.")
+	cmds.Flags().BoolVar(&o.hostInfo, "metrics.host-info", false,
+		"Expose the fs_exporter_host_info metric built from the hostname, machine-id and os-release.")
+	cmds.Flags().BoolVar(&o.enablePprof, "web.enable-pprof", false,
+		"Serve the /debug/pprof/ endpoints, behind the same authentication as the metrics.")
+	cmds.Flags().StringVar(&o.debugAddress, "web.debug-address", "",
+		"Address to serve the /debug/pprof/ endpoints on, instead of the listen address. Requires --web.enable-pprof.")
+	cmds.Flags().DurationVar(&o.shutdownTimeout, "web.shutdown-timeout", 30*time.Second,
+		"Time to let the in-flight scrapes finish on SIGTERM or SIGINT, they are cancelled afterwards.")
+	cmds.Flags().DurationVar(&o.timeoutOffset, "web.timeout-offset", 500*time.Millisecond,
+		"Offset to subtract from the timeout given by Prometheus in the X-Prometheus-Scrape-Timeout-Seconds header")
+	cmds.Flags().BoolVar(&o.disableDefaultCollectors, "collector.disable-defaults", false, "Set all collectors to disabled by default.")
 	cmds.Flags().StringSliceVar(&o.logConfig.LogOutputs, "log.outputs", []string{"stderr"},
 		"log outputs is a list of URLs or file paths to write logging output to.(default|stdout|stderr|file paths)")
+	o.push.addFlags(cmds.Flags())
+	o.remoteWrite.addFlags(cmds.Flags())
+	o.otlp.addFlags(cmds.Flags())
+	o.graphite.addFlags(cmds.Flags())
+	o.statsd.addFlags(cmds.Flags())
 
 	cmds.AddCommand(versionCmd)
 
@@ -78,77 +139,73 @@ func NewFSExporterCommand() *cobra.Command {
 func (o *fsExporterOptions) Run() error {
 	cobra.CheckErr(o.logConfig.Validate())
 	logger := o.logConfig.GetLogger()
-	logger.Info("Starting fs exporter")
-	logger.Debug("fs exporter options", zap.String("listen-address", o.listenAddress),
+	logger.Info("Starting fs exporter", zap.String("version", version.Info()))
+	logger.Info("Build context", zap.String("build-context", version.BuildContext()))
+	logger.Debug("fs exporter options", zap.Strings("listen-addresses", o.listenAddresses),
 		zap.String("metric-path", o.metricsPath), zap.Int64("max-requests", o.maxRequests))
 	logger.Debug("fs exporter logger options", zap.String("log-level", o.logConfig.LogLevel),
 		zap.Any("log-outputs", o.logConfig.LogOutputs))
 
-	http.Handle(o.metricsPath, newHandler(o.maxRequests, logger))
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte(`<html>
-			<head><title>File system Exporter</title></head>
-			<body>
-			<h1>File system Exporter</h1>
-			<p><a href="` + o.metricsPath + `">Metrics</a></p>
-			</body>
-			</html>`))
-	})
-	logger.Info("Listening on address", zap.String("listen-address", o.listenAddress))
-	if err := http.ListenAndServe(o.listenAddress, nil); err != nil {
-		logger.Error("error", zap.Error(err))
+	if o.debugAddress != "" && !o.enablePprof {
+		return fmt.Errorf("Invalid --web.debug-address %s, it requires --web.enable-pprof", o.debugAddress)
 	}
-
-	return nil
-}
-
-func newHandler(maxRequests int64, logger *zap.Logger) *handler {
-	return &handler{
-		maxRequests: maxRequests,
-		logger:      logger,
+	if o.disableDefaultCollectors {
+		collector.DisableDefaultCollectors()
 	}
-}
-
-type handler struct {
-	maxRequests int64
-	logger      *zap.Logger
-}
-
-// ServeHTTP implements http.Handler.
-func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	handler, err := h.innerHandler()
+	constLabels, err := o.parseConstLabels()
 	if err != nil {
-		h.logger.Error("Couldn't create metrics handler:", zap.Error(err))
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("Couldn't create metrics handler: %s", err)))
-		return
+		return err
 	}
-	handler.ServeHTTP(w, r)
-}
-
-func (h *handler) innerHandler() (http.Handler, error) {
-	fsc, err := collector.NewFSCollector(h.logger)
+	// Initialize the enabled collectors up front, so that the ones
+	// running in the background have results before the first scrape.
+	h, err := newHandler(handlerOptions{
+		includeExporterMetrics: !o.disableExporterMetrics,
+		maxRequests:            o.maxRequests,
+		timeoutOffset:          o.timeoutOffset,
+		constLabels:            constLabels,
+		hostInfo:               o.hostInfo,
+	}, logger)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to create collector: %s", err)
+		return err
 	}
 
-	for n := range fsc.Collectors {
-		h.logger.Info("Collector", zap.String("collector", n))
+	mux := http.NewServeMux()
+	mux.Handle(o.metricsPath, h.metricsHandler())
+	registerStatusHandlers(mux, logger)
+	mux.HandleFunc("/", o.landingHandler(logger))
+	var debugMux *http.ServeMux
+	if o.enablePprof {
+		if o.debugAddress == "" {
+			registerPprofHandlers(mux)
+		} else {
+			debugMux = http.NewServeMux()
+			registerPprofHandlers(debugMux)
+		}
 	}
 
-	rgst := prometheus.NewRegistry()
-	if err := rgst.Register(fsc); err != nil {
-		h.logger.Error("Couldn't register collector:", zap.Error(err))
-		return nil, err
+	return o.serve(h, mux, debugMux, logger)
+}
+
+// parseConstLabels merges the metrics.const-labels section of the config
+// file with the --metrics.const-label flags.
+func (o *fsExporterOptions) parseConstLabels() (prometheus.Labels, error) {
+	labels := prometheus.Labels{}
+	for k, v := range viper.GetStringMapString("metrics.const-labels") {
+		labels[k] = v
 	}
-	handler := promhttp.HandlerFor(
-		prometheus.Gatherers{rgst},
-		promhttp.HandlerOpts{
-			ErrorHandling:       promhttp.ContinueOnError,
-			MaxRequestsInFlight: int(h.maxRequests),
-		},
-	)
-	return handler, nil
+	for _, l := range o.constLabels {
+		kv := strings.SplitN(l, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid constant label %q, expected key=value", l)
+		}
+		labels[kv[0]] = kv[1]
+	}
+	for k := range labels {
+		if !model.LabelName(k).IsValid() || strings.HasPrefix(k, model.ReservedLabelPrefix) {
+			return nil, fmt.Errorf("invalid constant label name %q", k)
+		}
+	}
+	return labels, nil
 }
 
 func init() {
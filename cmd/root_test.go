@@ -0,0 +1,14 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDebugAddressRequiresPprof(t *testing.T) {
+	o := newFSExporterOptions()
+	o.debugAddress = "127.0.0.1:0"
+	if err := o.Run(); err == nil || !strings.Contains(err.Error(), "--web.enable-pprof") {
+		t.Errorf("got error %v, want --web.debug-address rejected without --web.enable-pprof", err)
+	}
+}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+)
+
+// namespace of the metrics of the senders, the same as the collectors.
+const namespace = "fs"
+
+// sender pushes the metrics of the collectors to a remote system, for the
+// hosts which can't be scraped.
+type sender interface {
+	// run sends the metrics until ctx is done.
+	run(ctx context.Context)
+	// close is called on shutdown, once run returned.
+	close(ctx context.Context)
+}
+
+// senders returns the enabled senders.
+func (o *fsExporterOptions) senders(h *handler, logger *zap.Logger) ([]sender, error) {
+	var senders []sender
+	if o.push.url != "" {
+		p, err := h.newPusher(&o.push, logger)
+		if err != nil {
+			return nil, err
+		}
+		senders = append(senders, p)
+	}
+	if len(o.remoteWrite.urls) > 0 {
+		w, err := h.newRemoteWriter(&o.remoteWrite, logger)
+		if err != nil {
+			return nil, err
+		}
+		senders = append(senders, w)
+	}
+	if o.otlp.endpoint != "" {
+		e, err := h.newOTLPExporter(&o.otlp, logger)
+		if err != nil {
+			return nil, err
+		}
+		senders = append(senders, e)
+	}
+	if o.graphite.address != "" {
+		g, err := h.newGraphiteSink(&o.graphite, logger)
+		if err != nil {
+			return nil, err
+		}
+		senders = append(senders, g)
+	}
+	if o.statsd.address != "" {
+		s, err := h.newStatsdSink(&o.statsd, logger)
+		if err != nil {
+			return nil, err
+		}
+		senders = append(senders, s)
+	}
+	return senders, nil
+}
+
+// runSenders runs the senders until ctx is done, and returns a function
+// waiting for them and closing them within the timeout.
+func runSenders(ctx context.Context, senders []sender, timeout time.Duration, logger *zap.Logger) func() {
+	var wg sync.WaitGroup
+	for _, s := range senders {
+		wg.Add(1)
+		go func(s sender) {
+			defer wg.Done()
+			s.run(ctx)
+		}(s)
+	}
+	return func() {
+		wg.Wait()
+		closeCtx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		for _, s := range senders {
+			s.close(closeCtx)
+		}
+		if len(senders) > 0 {
+			logger.Debug("senders closed")
+		}
+	}
+}
+
+// gather gathers the metrics of the target for a sender. Like a scrape, it is
+// bound by the timeouts of the collectors, the timeout of the sender only
+// bounds the sending. The metrics gathered despite the errors are still sent.
+func gather(ctx context.Context, target *scrapeTarget, logger *zap.Logger) []*dto.MetricFamily {
+	mfs, err := target.gather(ctx)
+	if err != nil {
+		logger.Warn("error gathering the metrics", zap.Error(err))
+	}
+	return mfs
+}
+
+// every calls f immediately and then on every interval until ctx is done.
+func every(ctx context.Context, interval time.Duration, f func(ctx context.Context)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		f(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
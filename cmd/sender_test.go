@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/microyahoo/fs_exporter/collector"
+)
+
+// newTestHandler returns a handler without any collector, it only serves
+// the metrics of the exporter scrapes.
+func newTestHandler(t *testing.T, opts handlerOptions) *handler {
+	t.Helper()
+	collector.DisableDefaultCollectors()
+	h, err := newHandler(opts, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// writeFile writes content to a file of a temporary directory, and returns its path.
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// serverCAFile writes the certificate of the TLS test server to a file, to
+// verify the server with.
+func serverCAFile(t *testing.T, server *httptest.Server) string {
+	t.Helper()
+	return writeFile(t, "ca.crt", string(pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: server.Certificate().Raw,
+	})))
+}
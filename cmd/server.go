@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"go.uber.org/zap"
+
+	"github.com/microyahoo/fs_exporter/collector"
+	"github.com/microyahoo/fs_exporter/pkg"
+	"github.com/microyahoo/fs_exporter/pkg/web"
+)
+
+// serve serves the mux on the listen addresses, and the debugMux on the debug
+// address if any, until SIGTERM or SIGINT. The in-flight scrapes are then
+// given --web.shutdown-timeout to finish before they are cancelled.
+func (o *fsExporterOptions) serve(h *handler, mux, debugMux *http.ServeMux, logger *zap.Logger) error {
+	senders, err := o.senders(h, logger)
+	if err != nil {
+		return err
+	}
+	mode, err := strconv.ParseUint(o.socketMode, 8, 32)
+	if err != nil {
+		return fmt.Errorf("Invalid --web.socket-mode %q: %s", o.socketMode, err)
+	}
+	var listeners []net.Listener
+	if o.systemdSocket {
+		listeners, err = web.SystemdListeners()
+	} else {
+		listeners, err = web.Listen(o.listenAddresses, os.FileMode(mode))
+	}
+	if err != nil {
+		return err
+	}
+	servers := []*http.Server{{Handler: mux}}
+	serverListeners := [][]net.Listener{listeners}
+	if debugMux != nil {
+		debugListeners, err := web.Listen([]string{o.debugAddress}, os.FileMode(mode))
+		if err != nil {
+			web.CloseListeners(listeners)
+			return err
+		}
+		servers = append(servers, &http.Server{Handler: debugMux})
+		serverListeners = append(serverListeners, debugListeners)
+	}
+
+	// The scrapes are bound to ctx, cancelling it kills the running collector commands.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	closeC := pkg.NewCloseNotifier()
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigC)
+	go func() {
+		select {
+		case sig := <-sigC:
+			logger.Warn("fs exporter received signal", zap.Any("signal", sig))
+			closeC.Close()
+		case <-ctx.Done():
+		}
+	}()
+
+	errC := make(chan error, len(servers))
+	for i, server := range servers {
+		server.ErrorLog = zap.NewStdLog(logger)
+		server.BaseContext = func(net.Listener) context.Context { return ctx }
+		for _, l := range serverListeners[i] {
+			logger.Info("Listening on address", zap.Stringer("listen-address", l.Addr()))
+		}
+		go func(server *http.Server, listeners []net.Listener) {
+			errC <- web.ServeMultiple(listeners, server, o.webConfigFile, logger)
+		}(server, serverListeners[i])
+	}
+
+	go h.warmUp(ctx)
+	closeSenders := runSenders(ctx, senders, o.shutdownTimeout, logger)
+
+	select {
+	case err = <-errC:
+		logger.Error("error", zap.Error(err))
+	case <-closeC.CloseNotify():
+	}
+
+	logger.Info("Shutting down fs exporter", zap.Duration("shutdown-timeout", o.shutdownTimeout))
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), o.shutdownTimeout)
+	defer shutdownCancel()
+	for _, server := range servers {
+		if serr := server.Shutdown(shutdownCtx); serr != nil {
+			logger.Warn("in-flight scrapes didn't finish in time, cancelling them", zap.Error(serr))
+			cancel()
+			server.Close()
+		}
+	}
+	cancel()
+	closeSenders()
+	collector.StopBackgroundCollectors()
+
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
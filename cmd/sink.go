@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+// placeholder matches the {label} placeholders of a path template.
+var placeholder = regexp.MustCompile(`\{([a-zA-Z_][a-zA-Z0-9_]*)\}`)
+
+// invalidPathChars matches the characters replaced in the path components.
+var invalidPathChars = regexp.MustCompile(`[^a-zA-Z0-9_:\-]`)
+
+// pathTemplate builds the dotted paths of the Graphite and StatsD sinks from
+// the labels of the series, e.g. "storage.{instance}.{__name__}". The labels
+// which aren't in the template are returned along with the path.
+type pathTemplate struct {
+	prefix   string
+	template string
+	used     map[string]bool
+}
+
+func newPathTemplate(prefix, template string) (*pathTemplate, error) {
+	if !strings.Contains(template, "{"+model.MetricNameLabel+"}") {
+		return nil, fmt.Errorf("Invalid path template %q, it must contain {%s}", template, model.MetricNameLabel)
+	}
+	t := &pathTemplate{prefix: prefix, template: template, used: make(map[string]bool)}
+	for _, m := range placeholder.FindAllStringSubmatch(template, -1) {
+		t.used[m[1]] = true
+	}
+	return t, nil
+}
+
+// render returns the path of the series, and its labels not used by the
+// template. The labels missing from the series leave out their component.
+func (t *pathTemplate) render(labels []label) (string, []label) {
+	values := make(map[string]string, len(labels))
+	var unused []label
+	for _, l := range labels {
+		values[l.name] = l.value
+		if !t.used[l.name] {
+			unused = append(unused, l)
+		}
+	}
+	path := placeholder.ReplaceAllStringFunc(t.template, func(p string) string {
+		return sanitizePath(values[p[1:len(p)-1]])
+	})
+	if t.prefix != "" {
+		path = t.prefix + "." + path
+	}
+	components := strings.Split(path, ".")
+	nonEmpty := components[:0]
+	for _, c := range components {
+		if c != "" {
+			nonEmpty = append(nonEmpty, c)
+		}
+	}
+	return strings.Join(nonEmpty, "."), unused
+}
+
+// sanitizePath replaces the dots and the characters not allowed in a path component.
+func sanitizePath(s string) string {
+	return invalidPathChars.ReplaceAllString(s, "_")
+}
+
+// isFinite reports whether v can be sent to the sinks, which don't support NaN and Inf.
+func isFinite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
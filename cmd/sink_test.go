@@ -0,0 +1,226 @@
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+// sinkFamilies are the metrics sent by the sink tests.
+func sinkFamilies() []*dto.MetricFamily {
+	return []*dto.MetricFamily{
+		{
+			Name: proto.String("fs_used_bytes"),
+			Type: dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{
+				{Label: []*dto.LabelPair{labelPair("site", "paris"), labelPair("volume", "data")}, Gauge: &dto.Gauge{Value: proto.Float64(42)}},
+				// NaN and Inf aren't supported by the sinks.
+				{Label: []*dto.LabelPair{labelPair("volume", "logs")}, Gauge: &dto.Gauge{Value: proto.Float64(math.NaN())}},
+				{Label: []*dto.LabelPair{labelPair("volume", "tmp")}, Gauge: &dto.Gauge{Value: proto.Float64(-2.5)}},
+			},
+		},
+		{
+			Name: proto.String("fs_errors_total"),
+			Type: dto.MetricType_COUNTER.Enum(),
+			Metric: []*dto.Metric{
+				{Label: []*dto.LabelPair{labelPair("collector", "zfs")}, Counter: &dto.Counter{Value: proto.Float64(3)}, TimestampMs: proto.Int64(5000)},
+				{Label: []*dto.LabelPair{labelPair("collector", "gluster")}, Counter: &dto.Counter{Value: proto.Float64(math.Inf(+1))}},
+			},
+		},
+	}
+}
+
+// listenTCP accepts a single connection, and returns its content once it is closed.
+func listenTCP(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan string, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			received <- ""
+			return
+		}
+		defer conn.Close()
+		b, _ := ioutil.ReadAll(conn)
+		received <- string(b)
+	}()
+	return l.Addr().String(), received
+}
+
+// listenUDP returns a function reading the packets received until none is
+// received for a while.
+func listenUDP(t *testing.T) (string, func() []string) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn.LocalAddr().String(), func() []string {
+		var packets []string
+		buf := make([]byte, 65536)
+		for {
+			conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return packets
+			}
+			packets = append(packets, string(buf[:n]))
+		}
+	}
+}
+
+func TestGraphiteSink(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	seconds := strconv.FormatInt(now.Unix(), 10)
+	for _, tc := range []struct {
+		name string
+		opts graphiteOptions
+		want []string
+	}{
+		{
+			// The components of the labels missing from the series are left out.
+			name: "template",
+			opts: graphiteOptions{prefix: "storage", template: "{site}.{__name__}"},
+			want: []string{
+				"storage.paris.fs_used_bytes.volume.data 42 " + seconds,
+				"storage.fs_used_bytes.volume.tmp -2.5 " + seconds,
+				"storage.fs_errors_total.collector.zfs 3 5",
+			},
+		},
+		{
+			name: "tags",
+			opts: graphiteOptions{template: "{__name__}", tags: true},
+			want: []string{
+				"fs_used_bytes;site=paris;volume=data 42 " + seconds,
+				"fs_used_bytes;volume=tmp -2.5 " + seconds,
+				"fs_errors_total;collector=zfs 3 5",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			addr, received := listenTCP(t)
+			tc.opts.address, tc.opts.interval, tc.opts.timeout = addr, time.Minute, time.Second
+			g, err := newTestHandler(t, handlerOptions{}).newGraphiteSink(&tc.opts, zap.NewNop())
+			if err != nil {
+				t.Fatal(err)
+			}
+			g.write(context.Background(), sinkFamilies(), now)
+			if got, want := <-received, strings.Join(tc.want, "\n")+"\n"; got != want {
+				t.Errorf("got lines\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestStatsdSink(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts statsdOptions
+		want []string
+	}{
+		{
+			// A negative gauge is reset first, a signed value changes it.
+			name: "template",
+			opts: statsdOptions{prefix: "storage", template: "{__name__}.{site}", maxPacketSize: 1432},
+			want: []string{"storage.fs_used_bytes.paris.volume.data:42|g\n" +
+				"storage.fs_used_bytes.volume.tmp:0|g\n" +
+				"storage.fs_used_bytes.volume.tmp:-2.5|g\n" +
+				"storage.fs_errors_total.collector.zfs:3|g"},
+		},
+		{
+			// DogStatsD sets the negative gauges.
+			name: "dogstatsd",
+			opts: statsdOptions{template: "{__name__}", dogstatsd: true, maxPacketSize: 1432},
+			want: []string{"fs_used_bytes:42|g|#site:paris,volume:data\n" +
+				"fs_used_bytes:-2.5|g|#volume:tmp\n" +
+				"fs_errors_total:3|g|#collector:zfs"},
+		},
+		{
+			// The lines are split in packets of at most --statsd.max-packet-size,
+			// a longer line is sent alone.
+			name: "packet split",
+			opts: statsdOptions{template: "{__name__}", maxPacketSize: 40},
+			want: []string{
+				"fs_used_bytes.site.paris.volume.data:42|g",
+				"fs_used_bytes.volume.tmp:0|g",
+				"fs_used_bytes.volume.tmp:-2.5|g",
+				"fs_errors_total.collector.zfs:3|g",
+			},
+		},
+		{
+			name: "packet join",
+			opts: statsdOptions{template: "{__name__}", maxPacketSize: 70},
+			want: []string{
+				"fs_used_bytes.site.paris.volume.data:42|g\nfs_used_bytes.volume.tmp:0|g",
+				"fs_used_bytes.volume.tmp:-2.5|g\nfs_errors_total.collector.zfs:3|g",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			addr, read := listenUDP(t)
+			tc.opts.address, tc.opts.interval, tc.opts.timeout = addr, time.Minute, time.Second
+			s, err := newTestHandler(t, handlerOptions{}).newStatsdSink(&tc.opts, zap.NewNop())
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.write(context.Background(), sinkFamilies())
+			got := read()
+			if strings.Join(got, "\n--\n") != strings.Join(tc.want, "\n--\n") {
+				t.Errorf("got packets %q, want %q", got, tc.want)
+			}
+			for _, p := range got {
+				if strings.Contains(p, "\n") && len(p) > tc.opts.maxPacketSize {
+					t.Errorf("got packet of %d bytes, want at most %d", len(p), tc.opts.maxPacketSize)
+				}
+			}
+		})
+	}
+}
+
+func TestGraphiteSinkSend(t *testing.T) {
+	addr, received := listenTCP(t)
+	opts := &graphiteOptions{address: addr, interval: time.Minute, timeout: time.Second, template: "{site}.{__name__}"}
+	h := newTestHandler(t, handlerOptions{constLabels: prometheus.Labels{"site": "paris"}})
+	g, err := h.newGraphiteSink(opts, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.send(context.Background())
+	// The const labels can be used in the template.
+	if got := <-received; !strings.Contains(got, "\nparis.fs_scrape_coalesced_total ") && !strings.HasPrefix(got, "paris.fs_scrape_coalesced_total ") {
+		t.Errorf("got lines without the exporter metrics:\n%s", got)
+	}
+}
+
+func TestPathTemplate(t *testing.T) {
+	if _, err := newPathTemplate("", "{instance}.{collector}"); err == nil {
+		t.Error("got no error for a template without {__name__}")
+	}
+	tmpl, err := newPathTemplate("", "{__name__}.{mount}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, unused := tmpl.render([]label{{"__name__", "fs_free"}, {"device", "sda.1"}, {"mount", "/var/lib"}})
+	if path != "fs_free._var_lib" {
+		t.Errorf("got path %q, want %q", path, "fs_free._var_lib")
+	}
+	if len(unused) != 1 || unused[0] != (label{"device", "sda.1"}) {
+		t.Errorf("got unused labels %v, want device", unused)
+	}
+}
@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+)
+
+// tagEscaper replaces the separators of the DogStatsD format in the tag values.
+var tagEscaper = strings.NewReplacer(",", "_", "|", "_", "\n", "_")
+
+// statsdOptions defines the StatsD sink.
+type statsdOptions struct {
+	address       string
+	interval      time.Duration
+	timeout       time.Duration
+	prefix        string
+	template      string
+	dogstatsd     bool
+	maxPacketSize int
+}
+
+func (s *statsdOptions) addFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&s.address, "statsd.address", "",
+		"Address of the StatsD server to send the metrics to over UDP, the sink is disabled if empty.")
+	flags.DurationVar(&s.interval, "statsd.interval", 10*time.Second, "Interval between the sends to StatsD.")
+	flags.DurationVar(&s.timeout, "statsd.timeout", 10*time.Second, "Timeout of a send to StatsD.")
+	flags.StringVar(&s.prefix, "statsd.prefix", "", "Prefix of the StatsD metric names.")
+	flags.StringVar(&s.template, "statsd.template", "{__name__}",
+		"Template of the StatsD metric names, {label} is replaced by the value of the label. The other labels are appended as .label.value.")
+	flags.BoolVar(&s.dogstatsd, "statsd.dogstatsd", false, "Send the labels which aren't in the template as DogStatsD tags (|#label:value) instead.")
+	flags.IntVar(&s.maxPacketSize, "statsd.max-packet-size", 1432, "Maximum size of the UDP packets.")
+}
+
+// statsdSink sends the metrics of the collectors as StatsD gauges.
+type statsdSink struct {
+	opts     *statsdOptions
+	template *pathTemplate
+	target   *scrapeTarget
+	logger   *zap.Logger
+}
+
+func (h *handler) newStatsdSink(opts *statsdOptions, logger *zap.Logger) (*statsdSink, error) {
+	if opts.interval <= 0 {
+		return nil, fmt.Errorf("Invalid --statsd.interval %s", opts.interval)
+	}
+	if opts.maxPacketSize <= 0 {
+		return nil, fmt.Errorf("Invalid --statsd.max-packet-size %d", opts.maxPacketSize)
+	}
+	template, err := newPathTemplate(opts.prefix, opts.template)
+	if err != nil {
+		return nil, err
+	}
+	return &statsdSink{
+		opts:     opts,
+		template: template,
+		target:   h.unfiltered,
+		logger:   logger.With(zap.String("sender", "statsd"), zap.String("address", opts.address)),
+	}, nil
+}
+
+func (s *statsdSink) run(ctx context.Context) {
+	every(ctx, s.opts.interval, s.send)
+}
+
+func (s *statsdSink) close(context.Context) {}
+
+func (s *statsdSink) send(ctx context.Context) {
+	mfs := gather(ctx, s.target, s.logger)
+	ctx, cancel := context.WithTimeout(ctx, s.opts.timeout)
+	defer cancel()
+	s.write(ctx, mfs)
+}
+
+// write sends the metric families to StatsD, in packets of at most
+// --statsd.max-packet-size unless a single line is larger.
+func (s *statsdSink) write(ctx context.Context, mfs []*dto.MetricFamily) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", s.opts.address)
+	if err != nil {
+		s.logger.Warn("failed to connect to StatsD", zap.Error(err))
+		return
+	}
+	defer conn.Close()
+
+	var packet bytes.Buffer
+	flush := func() {
+		if packet.Len() == 0 {
+			return
+		}
+		if _, err := conn.Write(packet.Bytes()); err != nil {
+			s.logger.Debug("failed to send a StatsD packet", zap.Error(err))
+		}
+		packet.Reset()
+	}
+	for _, ts := range toTimeSeries(mfs, nil, 0) {
+		if !isFinite(ts.value) {
+			continue
+		}
+		for _, line := range s.lines(ts) {
+			if packet.Len() > 0 && packet.Len()+1+len(line) > s.opts.maxPacketSize {
+				flush()
+			}
+			if packet.Len() > 0 {
+				packet.WriteByte('\n')
+			}
+			packet.WriteString(line)
+		}
+	}
+	flush()
+}
+
+// lines returns the gauge lines of the series.
+func (s *statsdSink) lines(ts timeSeries) []string {
+	name, unused := s.template.render(ts.labels)
+	var tags string
+	for i, l := range unused {
+		if s.opts.dogstatsd {
+			if i == 0 {
+				tags = "|#"
+			} else {
+				tags += ","
+			}
+			tags += l.name + ":" + tagEscaper.Replace(l.value)
+		} else {
+			name += "." + sanitizePath(l.name) + "." + sanitizePath(l.value)
+		}
+	}
+	value := strconv.FormatFloat(ts.value, 'f', -1, 64)
+	if ts.value < 0 && !s.opts.dogstatsd {
+		// A signed value changes the gauge of StatsD instead of setting it,
+		// so it is reset first.
+		return []string{name + ":0|g" + tags, name + ":" + value + "|g" + tags}
+	}
+	return []string{name + ":" + value + "|g" + tags}
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"github.com/microyahoo/fs_exporter/collector"
+)
+
+// registerStatusHandlers registers the health, readiness and collector
+// status endpoints on the mux.
+func registerStatusHandlers(mux *http.ServeMux, logger *zap.Logger) {
+	mux.HandleFunc("/-/healthy", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "Healthy.")
+	})
+	mux.HandleFunc("/-/ready", func(w http.ResponseWriter, r *http.Request) {
+		if ready, pending := collector.Ready(); !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "Not ready, waiting for the collectors: %s.\n", strings.Join(pending, ", "))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "Ready.")
+	})
+	mux.HandleFunc("/api/v1/collectors", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		resp := struct {
+			Collectors []collector.Status `json:"collectors"`
+		}{collector.Statuses()}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			logger.Warn("failed to write the collector status", zap.Error(err))
+		}
+	})
+}
+
+// warmUp runs the enabled collectors once, so that the exporter becomes ready
+// without waiting for the first scrape.
+func (h *handler) warmUp(ctx context.Context) {
+	ch := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		for range ch {
+		}
+		close(done)
+	}()
+	h.unfiltered.fsc.CollectWithContext(ctx, ch)
+	close(ch)
+	<-done
+	h.logger.Debug("collectors warmed up")
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/microyahoo/fs_exporter/collector"
+)
+
+func get(t *testing.T, h http.Handler, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	return w
+}
+
+func glusterfsStatus(t *testing.T, h http.Handler) collector.Status {
+	t.Helper()
+	var resp struct {
+		Collectors []collector.Status `json:"collectors"`
+	}
+	w := get(t, h, "/api/v1/collectors")
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("got %q: %v", w.Body.String(), err)
+	}
+	for _, s := range resp.Collectors {
+		if s.Name == "glusterfs" {
+			return s
+		}
+	}
+	t.Fatalf("got %q without the glusterfs collector", w.Body.String())
+	return collector.Status{}
+}
+
+func TestStatusHandlers(t *testing.T) {
+	// The glusterfs collector isn't run by the other tests.
+	setCollectorFlags(t, "--collector.glusterfs")
+	t.Cleanup(func() { setCollectorFlags(t, "--no-collector.glusterfs") })
+	collector.DisableDefaultCollectors()
+	h, err := newHandler(handlerOptions{}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	registerStatusHandlers(mux, zap.NewNop())
+
+	if w := get(t, mux, "/-/healthy"); w.Code != http.StatusOK {
+		t.Errorf("healthy: got %d, want %d", w.Code, http.StatusOK)
+	}
+	// The collectors outlive the test, glusterfs only hasn't run yet on the
+	// first -count.
+	if s := glusterfsStatus(t, mux); s.LastRun == nil {
+		if !s.Enabled || s.Breaker != collector.BreakerClosed {
+			t.Errorf("got status %+v before the first run, want enabled and a closed breaker", s)
+		}
+		w := get(t, mux, "/-/ready")
+		if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "glusterfs") {
+			t.Errorf("ready before the first run: got %d %q, want %d waiting for glusterfs",
+				w.Code, w.Body.String(), http.StatusServiceUnavailable)
+		}
+	}
+
+	h.warmUp(context.Background())
+	if w := get(t, mux, "/-/ready"); w.Code != http.StatusOK {
+		t.Errorf("ready after the warm up: got %d %q, want %d", w.Code, w.Body.String(), http.StatusOK)
+	}
+	if s := glusterfsStatus(t, mux); s.LastRun == nil || s.LastError != "" || s.Background {
+		t.Errorf("got status %+v after the warm up, want a successful run", s)
+	}
+}
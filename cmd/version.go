@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 
+	"github.com/prometheus/common/version"
 	"github.com/spf13/cobra"
 )
 
@@ -17,13 +18,16 @@ Cobra is a CLI library for Go that empowers applications.
 This application is a tool to generate the needed files
 to quickly create a Cobra application.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("fs_exporter version is v0.1")
+		fmt.Println(version.Print("fs_exporter"))
 	},
 	TraverseChildren: true,
 }
 
 func init() {
-	// rootCmd.AddCommand(versionCmd)
+	// The build information is set with -ldflags, see the Makefile.
+	if version.Version == "" {
+		version.Version = "v0.1"
+	}
 
 	// Here you will define your flags and configuration settings.
 
@@ -0,0 +1,114 @@
+package collector
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+var scrapeCacheAgeDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "scrape", "collector_cache_age_seconds"),
+	"Age of the cached results of a collector running in the background.",
+	[]string{"collector"},
+	nil,
+)
+
+var (
+	backgroundCtx, stopBackground = context.WithCancel(context.Background())
+	backgroundWg                  sync.WaitGroup
+	backgroundCollectors          = make(map[string]*backgroundCollector)
+)
+
+// backgroundCollector runs a collector on its own schedule and keeps
+// the metrics of its latest run, which are served to every scrape.
+type backgroundCollector struct {
+	name     string
+	r        *collectorRun
+	interval time.Duration
+	logger   *zap.Logger
+
+	mtx     sync.RWMutex
+	metrics []prometheus.Metric
+	updated time.Time
+}
+
+// startBackgroundCollector must be called with collectorMutex held.
+func startBackgroundCollector(r *collectorRun, interval time.Duration) {
+	b := &backgroundCollector{
+		name:     r.name,
+		r:        r,
+		interval: interval,
+		logger:   r.logger,
+	}
+	backgroundCollectors[r.name] = b
+	b.logger.Info("Starting background collector", zap.String("collector", r.name), zap.Duration("interval", interval))
+
+	backgroundWg.Add(1)
+	go func() {
+		defer backgroundWg.Done()
+		b.run(backgroundCtx)
+	}()
+}
+
+// StopBackgroundCollectors cancels the collectors running in the background
+// and waits for them to return.
+func StopBackgroundCollectors() {
+	stopBackground()
+	backgroundWg.Wait()
+}
+
+func (b *backgroundCollector) run(ctx context.Context) {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		b.refresh(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (b *backgroundCollector) refresh(ctx context.Context) {
+	ch := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	var metrics []prometheus.Metric
+	go func() {
+		defer close(done)
+		for m := range ch {
+			metrics = append(metrics, m)
+		}
+	}()
+	duration, dropped, err := b.r.run(ctx, ch)
+	close(ch)
+	<-done
+	if ctx.Err() != nil || err == errBreakerOpen {
+		// Shutting down or skipped, keep the previous results.
+		return
+	}
+	metrics = append(metrics, scrapeMetrics(b.name, duration, dropped, err)...)
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.metrics = metrics
+	b.updated = time.Now()
+}
+
+// collect sends the cached metrics, nothing is sent before the first run completes.
+func (b *backgroundCollector) collect(ch chan<- prometheus.Metric) {
+	b.mtx.RLock()
+	metrics, updated := b.metrics, b.updated
+	b.mtx.RUnlock()
+	if updated.IsZero() {
+		b.logger.Debug("background collector has no results yet", zap.String("collector", b.name))
+		return
+	}
+	for _, m := range metrics {
+		ch <- m
+	}
+	ch <- prometheus.MustNewConstMetric(scrapeCacheAgeDesc, prometheus.GaugeValue, time.Since(updated).Seconds(), b.name)
+}
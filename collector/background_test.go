@@ -0,0 +1,104 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// countingCollector sends the number of its runs, it fails when err is set.
+type countingCollector struct {
+	mtx  sync.Mutex
+	runs int
+	err  error
+}
+
+func (c *countingCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	c.mtx.Lock()
+	c.runs++
+	runs, err := c.runs, c.err
+	c.mtx.Unlock()
+	if err != nil {
+		return err
+	}
+	ch <- prometheus.MustNewConstMetric(testDesc, prometheus.GaugeValue, float64(runs), "a")
+	return nil
+}
+
+// newTestBackgroundCollector returns an FSCollector serving c from the cache
+// of a background collector, which is only refreshed by the test.
+func newTestBackgroundCollector(t *testing.T, c Collector) (*FSCollector, *backgroundCollector) {
+	t.Helper()
+	n := newTestFSCollector(t, map[string]Collector{"cached": c})
+	r := n.runs["cached"]
+	b := &backgroundCollector{name: r.name, r: r, interval: time.Hour, logger: r.logger}
+	n.background["cached"] = b
+	return n, b
+}
+
+func TestBackgroundCollectorServesTheCache(t *testing.T) {
+	c := &countingCollector{}
+	n, b := newTestBackgroundCollector(t, c)
+
+	// Nothing is served before the first run.
+	metrics := collectWithContext(context.Background(), n)
+	if _, ok := gaugeValue(t, metrics, scrapeSuccessDesc, "cached"); ok {
+		t.Error("got results before the first run")
+	}
+
+	b.refresh(context.Background())
+	for i := 0; i < 2; i++ {
+		metrics := collectWithContext(context.Background(), n)
+		if value, _ := gaugeValue(t, metrics, testDesc, "a"); value != 1 {
+			t.Errorf("scrape %d: got the metric of run %v, want 1", i, value)
+		}
+		if success, _ := gaugeValue(t, metrics, scrapeSuccessDesc, "cached"); success != 1 {
+			t.Errorf("scrape %d: got success %v, want 1", i, success)
+		}
+	}
+	if c.runs != 1 {
+		t.Errorf("the scrapes ran the collector %d times, want 1", c.runs)
+	}
+}
+
+func TestBackgroundCollectorCacheAge(t *testing.T) {
+	n, b := newTestBackgroundCollector(t, &countingCollector{})
+	b.refresh(context.Background())
+	b.mtx.Lock()
+	b.updated = b.updated.Add(-time.Minute)
+	b.mtx.Unlock()
+
+	metrics := collectWithContext(context.Background(), n)
+	if age, ok := gaugeValue(t, metrics, scrapeCacheAgeDesc, "cached"); !ok || age < 60 || age > 70 {
+		t.Errorf("got cache age %v, want about 60s", age)
+	}
+}
+
+func TestBackgroundCollectorKeepsTheCacheWhenSkipped(t *testing.T) {
+	c := &countingCollector{}
+	n, b := newTestBackgroundCollector(t, c)
+	br := n.runs["cached"].breaker
+	br.threshold, br.backoff, br.maxBackoff = 1, time.Hour, time.Hour
+
+	b.refresh(context.Background())
+	// The failed run replaces the cache, and opens the breaker.
+	c.err = errors.New("failed")
+	b.refresh(context.Background())
+	updated := b.updated
+
+	b.refresh(context.Background())
+	if c.runs != 2 {
+		t.Errorf("got %d runs, want the third one skipped by the breaker", c.runs)
+	}
+	if b.updated != updated {
+		t.Error("the skipped run replaced the cache")
+	}
+	metrics := collectWithContext(context.Background(), n)
+	if success, ok := gaugeValue(t, metrics, scrapeSuccessDesc, "cached"); !ok || success != 0 {
+		t.Errorf("got success %v, want the 0 of the failed run", success)
+	}
+}
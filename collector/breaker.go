@@ -0,0 +1,157 @@
+package collector
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+)
+
+// circuit breaker parameters
+var (
+	breakerThreshold  int
+	breakerBackoff    time.Duration
+	breakerMaxBackoff time.Duration
+)
+
+var (
+	breakerOpenDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "scrape", "collector_breaker_open"),
+		"Whether the circuit breaker of a collector is open, its runs are skipped while it is open.",
+		[]string{"collector"},
+		nil,
+	)
+	breakerNextRetryDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "scrape", "collector_breaker_next_retry_timestamp_seconds"),
+		"Time at which a collector with an open circuit breaker is run again, 0 if the breaker is closed.",
+		[]string{"collector"},
+		nil,
+	)
+)
+
+func addBreakerFlags(flags *pflag.FlagSet) {
+	flags.IntVar(&breakerThreshold, "collector.breaker.threshold", 5,
+		"Number of consecutive failures after which a collector is skipped. Use 0 to disable the circuit breaker.")
+	flags.DurationVar(&breakerBackoff, "collector.breaker.backoff", 30*time.Second,
+		"Time to skip a collector once its circuit breaker opens, it doubles each time the retry fails.")
+	flags.DurationVar(&breakerMaxBackoff, "collector.breaker.max-backoff", 10*time.Minute,
+		"Maximum time to skip a collector whose circuit breaker is open.")
+}
+
+// breaker tracks the consecutive failures of a collector, and skips its
+// runs with an exponential backoff once they reach the threshold.
+type breaker struct {
+	name       string
+	threshold  int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	logger     *zap.Logger
+
+	mtx       sync.Mutex
+	failures  int
+	open      bool
+	current   time.Duration // current backoff
+	nextRetry time.Time
+}
+
+func newBreaker(name string, logger *zap.Logger) *breaker {
+	return &breaker{
+		name:       name,
+		threshold:  breakerThreshold,
+		backoff:    breakerBackoff,
+		maxBackoff: breakerMaxBackoff,
+		logger:     logger,
+	}
+}
+
+// allow reports whether the collector may run. Once the backoff of an open
+// breaker expired a single run is let through to probe the collector.
+func (b *breaker) allow() bool {
+	if b.threshold <= 0 {
+		return true
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if !b.open {
+		return true
+	}
+	now := time.Now()
+	if now.Before(b.nextRetry) {
+		return false
+	}
+	// Hold back the other runs while this one probes the collector.
+	b.nextRetry = now.Add(b.current)
+	return true
+}
+
+// record updates the breaker with the outcome of a run, no data isn't a
+// failure and the cancelled runs are ignored.
+func (b *breaker) record(err error) {
+	if b.threshold <= 0 || isCanceled(err) {
+		return
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if err == nil || IsNoDataError(err) {
+		if b.open {
+			b.logger.Info("collector circuit breaker closed", zap.String("name", b.name))
+		}
+		b.failures = 0
+		b.open = false
+		b.current = 0
+		b.nextRetry = time.Time{}
+		return
+	}
+
+	b.failures++
+	if b.open {
+		b.current *= 2
+		if b.current > b.maxBackoff {
+			b.current = b.maxBackoff
+		}
+		b.nextRetry = time.Now().Add(b.current)
+		b.logger.Debug("collector circuit breaker retry failed", zap.String("name", b.name),
+			zap.Int("failures", b.failures), zap.Time("next_retry", b.nextRetry))
+		return
+	}
+	if b.failures >= b.threshold {
+		b.open = true
+		b.current = b.backoff
+		if b.current > b.maxBackoff {
+			b.current = b.maxBackoff
+		}
+		b.nextRetry = time.Now().Add(b.current)
+		b.logger.Warn("collector circuit breaker opened", zap.String("name", b.name),
+			zap.Int("failures", b.failures), zap.Time("next_retry", b.nextRetry))
+	}
+}
+
+func (b *breaker) collect(ch chan<- prometheus.Metric) {
+	b.mtx.Lock()
+	open, nextRetry := b.open, b.nextRetry
+	b.mtx.Unlock()
+
+	var openValue, nextRetryValue float64
+	if open {
+		openValue = 1
+		nextRetryValue = float64(nextRetry.UnixNano()) / 1e9
+	}
+	ch <- prometheus.MustNewConstMetric(breakerOpenDesc, prometheus.GaugeValue, openValue, b.name)
+	ch <- prometheus.MustNewConstMetric(breakerNextRetryDesc, prometheus.GaugeValue, nextRetryValue, b.name)
+}
+
+// state returns the state of the breaker, and the next retry when it is open.
+func (b *breaker) state() (string, *time.Time) {
+	if b.threshold <= 0 {
+		return BreakerDisabled, nil
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if !b.open {
+		return BreakerClosed, nil
+	}
+	nextRetry := b.nextRetry
+	return BreakerOpen, &nextRetry
+}
@@ -0,0 +1,63 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+)
+
+func TestBreakerOpensAfterThreshold(t *testing.T) {
+	b := &breaker{name: "test", threshold: 2, backoff: time.Minute, maxBackoff: time.Hour, logger: zap.NewNop()}
+	b.record(errors.New("failed"))
+	if !b.allow() {
+		t.Fatal("the breaker opened before its threshold")
+	}
+	b.record(&ExecError{Command: "gluster", Err: errors.New("exit status 1")})
+	if b.allow() {
+		t.Fatal("the breaker didn't open at its threshold")
+	}
+	if state, _ := b.state(); state != BreakerOpen {
+		t.Errorf("got state %s, want %s", state, BreakerOpen)
+	}
+}
+
+func TestBreakerIgnoresCancelledRuns(t *testing.T) {
+	b := &breaker{name: "test", threshold: 2, backoff: time.Minute, maxBackoff: time.Hour, logger: zap.NewNop()}
+	for i := 0; i < 10; i++ {
+		b.record(context.Canceled)
+		b.record(newExecError("gluster", nil, fmt.Errorf("signal: killed: %w", context.Canceled)))
+	}
+	if !b.allow() {
+		t.Error("cancelled runs opened the breaker")
+	}
+	b.record(context.DeadlineExceeded)
+	b.record(&TimeoutError{Err: context.DeadlineExceeded})
+	if b.allow() {
+		t.Error("timeouts didn't open the breaker")
+	}
+}
+
+func TestCancelledRunIsNotAFailure(t *testing.T) {
+	n := newTestFSCollector(t, map[string]Collector{"cancelled": &sleepCollector{sleep: time.Hour}})
+	r := n.runs["cancelled"]
+	before := testutil.ToFloat64(scrapeErrors.WithLabelValues("cancelled", reasonUnknown))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err := r.run(ctx, make(chan<- prometheus.Metric))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	if after := testutil.ToFloat64(scrapeErrors.WithLabelValues("cancelled", reasonUnknown)); after != before {
+		t.Errorf("the cancelled run was counted as an error")
+	}
+	if !r.lastRun.IsZero() {
+		t.Error("the cancelled run was recorded as completed")
+	}
+}
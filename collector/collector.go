@@ -1,18 +1,26 @@
 package collector
 
 import (
-	"errors"
+	"context"
 	"fmt"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/pflag"
 	"go.uber.org/zap"
 )
 
 // Namespace defines the common namespace to be used by all metrics.
 const namespace = "fs"
 
+const (
+	defaultEnabled  = true
+	defaultDisabled = false
+)
+
 var (
 	scrapeDurationDesc = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "scrape", "collector_duration_seconds"),
@@ -26,6 +34,18 @@ var (
 		[]string{"collector"},
 		nil,
 	)
+	scrapeTimeoutDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "scrape", "collector_timeout"),
+		"Whether a collector was cancelled because it timed out.",
+		[]string{"collector"},
+		nil,
+	)
+	scrapeErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "scrape",
+		Name:      "collector_errors_total",
+		Help:      "Total number of collector runs which failed, by reason.",
+	}, []string{"collector", "reason"})
 )
 
 var (
@@ -33,92 +53,395 @@ var (
 	factoryMutex          sync.Mutex
 	initializedCollectors = make(map[string]Collector)
 	collectorFactories    = make(map[string]func(*zap.Logger) (Collector, error))
+	collectorState        = make(map[string]*bool)
+	collectorTimeouts     = make(map[string]*time.Duration)
+	collectorIntervals    = make(map[string]*time.Duration)
+	collectorExecModes    = make(map[string]*string)
+	collectorSeriesLimits = make(map[string]*int)
+	forcedCollectors      = make(map[string]bool) // collectors which have been explicitly enabled or disabled
 )
 
-func registerCollector(name string, factory func(*zap.Logger) (Collector, error)) {
+func registerCollector(name string, isDefaultEnabled bool, factory func(*zap.Logger) (Collector, error)) {
 	factoryMutex.Lock()
 	defer factoryMutex.Unlock()
 	if _, ok := collectorFactories[name]; ok {
 		panic(fmt.Sprintf("The collector of %s has already been registered", name))
 	}
+	enabled := isDefaultEnabled
+	collectorState[name] = &enabled
+	collectorTimeouts[name] = new(time.Duration)
+	collectorIntervals[name] = new(time.Duration)
+	collectorExecModes[name] = new(string)
+	collectorSeriesLimits[name] = new(int)
 	collectorFactories[name] = factory
 }
 
+// collectorStateValue implements pflag.Value for the --collector.<name> and
+// --no-collector.<name> flags, which share the same collector state.
+type collectorStateValue struct {
+	name   string
+	negate bool
+}
+
+func (v *collectorStateValue) Set(s string) error {
+	enabled, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	if v.negate {
+		enabled = !enabled
+	}
+	factoryMutex.Lock()
+	defer factoryMutex.Unlock()
+	*collectorState[v.name] = enabled
+	forcedCollectors[v.name] = true
+	return nil
+}
+
+func (v *collectorStateValue) String() string {
+	factoryMutex.Lock()
+	defer factoryMutex.Unlock()
+	if state, ok := collectorState[v.name]; ok {
+		return strconv.FormatBool(*state != v.negate)
+	}
+	return strconv.FormatBool(v.negate)
+}
+
+func (v *collectorStateValue) Type() string {
+	return "bool"
+}
+
+// AddCollectorFlags adds the --collector.<name> and --no-collector.<name> flags
+// for every registered collector.
+func AddCollectorFlags(flags *pflag.FlagSet) {
+	factoryMutex.Lock()
+	names := make([]string, 0, len(collectorState))
+	for name := range collectorState {
+		names = append(names, name)
+	}
+	factoryMutex.Unlock()
+	sort.Strings(names)
+
+	for _, name := range names {
+		flag := flags.VarPF(&collectorStateValue{name: name}, "collector."+name, "",
+			fmt.Sprintf("Enable the %s collector.", name))
+		flag.NoOptDefVal = "true"
+		flag = flags.VarPF(&collectorStateValue{name: name, negate: true}, "no-collector."+name, "",
+			fmt.Sprintf("Disable the %s collector.", name))
+		flag.NoOptDefVal = "true"
+		flags.DurationVar(collectorTimeouts[name], "collector."+name+".timeout", 0,
+			fmt.Sprintf("Timeout of the %s collector, it is cancelled when exceeded. Use 0 to only apply the scrape timeout.", name))
+		flags.DurationVar(collectorIntervals[name], "collector."+name+".interval", 0,
+			fmt.Sprintf("Interval to run the %s collector in the background, scrapes are served from its latest results. Use 0 to collect on every scrape.", name))
+		flags.StringVar(collectorExecModes[name], "collector."+name+".exec-mode", execModeHost,
+			fmt.Sprintf("How the %s collector runs its commands. One of (host|nsenter|chroot), nsenter and chroot run the host's binaries from a container.", name))
+		flags.IntVar(collectorSeriesLimits[name], "collector."+name+".series-limit", 0,
+			fmt.Sprintf("Maximum number of series exposed by the %s collector, the others are dropped. Use 0 for no limit.", name))
+	}
+	addBreakerFlags(flags)
+	addFilterFlags(flags)
+}
+
+// DisableDefaultCollectors sets the collector state to false for all collectors which
+// have not been explicitly enabled on the command line.
+func DisableDefaultCollectors() {
+	factoryMutex.Lock()
+	defer factoryMutex.Unlock()
+	for name := range collectorState {
+		if _, ok := forcedCollectors[name]; !ok {
+			*collectorState[name] = false
+		}
+	}
+}
+
 // Collector is the interface a collector has to implement.
 type Collector interface {
 	// Get new metrics and expose them via prometheus registry.
-	Update(ch chan<- prometheus.Metric) error
+	// The ctx is cancelled when the collector times out, any
+	// command started by the collector must be bound to it.
+	Update(ctx context.Context, ch chan<- prometheus.Metric) error
 }
 
 // FSCollector implements the prometheus.Collector interface.
 type FSCollector struct {
 	Collectors map[string]Collector
+	runs       map[string]*collectorRun
+	background map[string]*backgroundCollector
 	logger     *zap.Logger
 }
 
-// NewFSCollector creates a new fs collector
-func NewFSCollector(logger *zap.Logger) (*FSCollector, error) {
+// collectorRuns are shared by all the FSCollectors, they are guarded by collectorMutex.
+var collectorRuns = make(map[string]*collectorRun)
+
+// NewFSCollector creates a new fs collector, the filters restrict it to
+// the named collectors, otherwise all the enabled collectors are used.
+func NewFSCollector(logger *zap.Logger, filters ...string) (*FSCollector, error) {
+	f := make(map[string]bool)
+	for _, filter := range filters {
+		if err := CheckCollectors(filter); err != nil {
+			return nil, err
+		}
+		if !*collectorState[filter] {
+			return nil, fmt.Errorf("disabled collector: %s", filter)
+		}
+		f[filter] = true
+	}
+	filter, err := newMetricFilter()
+	if err != nil {
+		return nil, err
+	}
 	collectors := make(map[string]Collector)
+	runs := make(map[string]*collectorRun)
+	background := make(map[string]*backgroundCollector)
 
 	collectorMutex.Lock()
 	defer collectorMutex.Unlock()
-	for name, factory := range collectorFactories {
+	for name, enabled := range collectorState {
+		if !*enabled || (len(f) > 0 && !f[name]) {
+			continue
+		}
 		if c, ok := initializedCollectors[name]; ok {
 			collectors[name] = c
 		} else {
-			c, err := factory(logger)
+			c, err := collectorFactories[name](logger)
 			if err != nil {
 				return nil, err
 			}
 			collectors[name] = c
 			initializedCollectors[name] = c
+			collectorRuns[name] = &collectorRun{
+				name:        name,
+				c:           c,
+				timeout:     *collectorTimeouts[name],
+				breaker:     newBreaker(name, logger),
+				filter:      filter,
+				seriesLimit: *collectorSeriesLimits[name],
+				logger:      logger,
+			}
+			if interval := *collectorIntervals[name]; interval > 0 {
+				startBackgroundCollector(collectorRuns[name], interval)
+			}
+		}
+		runs[name] = collectorRuns[name]
+		if b, ok := backgroundCollectors[name]; ok {
+			background[name] = b
 		}
 	}
 	return &FSCollector{
 		Collectors: collectors,
+		runs:       runs,
+		background: background,
 		logger:     logger,
 	}, nil
 }
 
+// CheckCollectors returns an error if any of the names is not a registered collector.
+func CheckCollectors(names ...string) error {
+	factoryMutex.Lock()
+	defer factoryMutex.Unlock()
+	for _, name := range names {
+		if _, ok := collectorFactories[name]; !ok {
+			return fmt.Errorf("unknown collector: %s", name)
+		}
+	}
+	return nil
+}
+
 // Describe implements the prometheus.Collector interface.
 func (n *FSCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- scrapeDurationDesc
 	ch <- scrapeSuccessDesc
+	ch <- scrapeTimeoutDesc
+	ch <- scrapeCacheAgeDesc
+	ch <- scrapeSeriesDroppedDesc
+	ch <- breakerOpenDesc
+	ch <- breakerNextRetryDesc
+	ch <- scrapesCoalesced.Desc()
+	scrapeErrors.Describe(ch)
+	execDuration.Describe(ch)
 }
 
 // Collect implements the prometheus.Collector interface.
 func (n *FSCollector) Collect(ch chan<- prometheus.Metric) {
-	wg := sync.WaitGroup{}
-	wg.Add(len(n.Collectors))
-	for name, c := range n.Collectors {
-		go func(name string, c Collector) {
-			defer wg.Done()
-			n.execute(name, c, ch)
-		}(name, c)
+	n.CollectWithContext(context.Background(), ch)
+}
+
+// CollectWithContext runs all the collectors, the ctx bounds the whole scrape.
+// Concurrent scrapes of the same collectors share a single collection.
+func (n *FSCollector) CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	begin := time.Now()
+	results := n.coalesce(ctx)
+	for name := range n.Collectors {
+		metrics, ok := results[name]
+		if !ok {
+			n.gaveUp(ctx, name, time.Since(begin), ch)
+			continue
+		}
+		for _, m := range metrics {
+			ch <- m
+		}
+	}
+	ch <- scrapesCoalesced
+	scrapeErrors.Collect(ch)
+	execDuration.Collect(ch)
+}
+
+func (n *FSCollector) execute(ctx context.Context, name string, c Collector, ch chan<- prometheus.Metric) {
+	r := n.runs[name]
+	defer r.breaker.collect(ch)
+	if b, ok := n.background[name]; ok {
+		b.collect(ch)
+		return
+	}
+	duration, dropped, err := r.run(ctx, ch)
+	for _, m := range scrapeMetrics(name, duration, dropped, err) {
+		ch <- m
+	}
+}
+
+// gaveUp reports a collector still running when the ctx of a scrape was done
+// as failed, a background one is still served from its cache. The error is
+// counted by the run itself, once it ends.
+func (n *FSCollector) gaveUp(ctx context.Context, name string, duration time.Duration, ch chan<- prometheus.Metric) {
+	r := n.runs[name]
+	defer r.breaker.collect(ch)
+	if b, ok := n.background[name]; ok {
+		b.collect(ch)
+		return
 	}
-	wg.Wait()
+	err := ctx.Err()
+	if IsTimeoutError(err) {
+		err = &TimeoutError{Err: err}
+	}
+	for _, m := range scrapeMetrics(name, duration, 0, err) {
+		ch <- m
+	}
+}
+
+// collectorRun runs a collector with its timeout, circuit breaker and series filter.
+type collectorRun struct {
+	name        string
+	c           Collector
+	timeout     time.Duration
+	breaker     *breaker
+	filter      *metricFilter
+	seriesLimit int
+	logger      *zap.Logger
+
+	// outcome of the last run which wasn't skipped by the breaker
+	mtx          sync.Mutex
+	lastRun      time.Time
+	lastDuration time.Duration
+	lastErr      error
 }
 
-func (n *FSCollector) execute(name string, c Collector, ch chan<- prometheus.Metric) {
+// run runs the collector once unless its circuit breaker is open, and logs its outcome.
+// It returns the number of series dropped because of the series limit.
+func (r *collectorRun) run(ctx context.Context, ch chan<- prometheus.Metric) (time.Duration, int, error) {
+	name, logger := r.name, r.logger
+	if !r.breaker.allow() {
+		logger.Debug("collector skipped by its circuit breaker", zap.String("name", name))
+		return 0, 0, errBreakerOpen
+	}
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+	series := 0
+	dropped := 0
+	keep := func(m prometheus.Metric) bool {
+		if !r.filter.keep(m) {
+			return false
+		}
+		if r.seriesLimit > 0 && series >= r.seriesLimit {
+			dropped++
+			return false
+		}
+		series++
+		return true
+	}
 	begin := time.Now()
-	err := c.Update(ch)
+	err := update(ctx, r.c, ch, keep)
 	duration := time.Since(begin)
-	var success float64
+	if isCanceled(err) {
+		logger.Debug("collector cancelled", zap.String("name", name),
+			zap.Float64("duration_seconds", duration.Seconds()), zap.Error(err))
+		return duration, dropped, err
+	}
+	r.breaker.record(err)
+	r.mtx.Lock()
+	r.lastRun, r.lastDuration, r.lastErr = begin, duration, err
+	r.mtx.Unlock()
 
+	if dropped > 0 {
+		logger.Warn("collector exceeded its series limit", zap.String("name", name),
+			zap.Int("series_limit", r.seriesLimit), zap.Int("dropped", dropped))
+	}
 	if err != nil {
+		reason := errorReason(err)
+		scrapeErrors.WithLabelValues(name, reason).Inc()
+		fields := append([]zap.Field{zap.String("name", name), zap.String("reason", reason),
+			zap.Float64("duration_seconds", duration.Seconds()), zap.Error(err)}, errorFields(err)...)
 		if IsNoDataError(err) {
-			n.logger.Debug("collector returned no data", zap.String("name", name),
-				zap.Float64("duration_seconds", duration.Seconds()), zap.Error(err))
+			logger.Debug("collector returned no data", fields...)
+		} else if IsTimeoutError(err) {
+			logger.Error("collector timed out", fields...)
 		} else {
-			n.logger.Error("collector failed", zap.String("name", name), zap.Float64("duration_seconds", duration.Seconds()), zap.Error(err))
+			logger.Error("collector failed", fields...)
 		}
-		success = 0
 	} else {
-		n.logger.Debug("collector succeeded", zap.String("name", name), zap.Float64("duration_seconds", duration.Seconds()))
+		logger.Debug("collector succeeded", zap.String("name", name), zap.Float64("duration_seconds", duration.Seconds()))
+	}
+	return duration, dropped, err
+}
+
+// scrapeMetrics returns the metrics describing a single run of the collector.
+func scrapeMetrics(name string, duration time.Duration, dropped int, err error) []prometheus.Metric {
+	var success, timedOut float64
+	if err == nil {
 		success = 1
+	} else if IsTimeoutError(err) {
+		timedOut = 1
+	}
+	return []prometheus.Metric{
+		prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, duration.Seconds(), name),
+		prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, success, name),
+		prometheus.MustNewConstMetric(scrapeTimeoutDesc, prometheus.GaugeValue, timedOut, name),
+		prometheus.MustNewConstMetric(scrapeSeriesDroppedDesc, prometheus.GaugeValue, float64(dropped), name),
+	}
+}
+
+// update runs c.Update and forwards the metrics to keep to ch until the ctx is done.
+// A collector which doesn't return in time is abandoned, the metrics it sends
+// afterwards are discarded so that they never reach a finished scrape.
+func update(ctx context.Context, c Collector, ch chan<- prometheus.Metric, keep func(prometheus.Metric) bool) error {
+	metrics := make(chan prometheus.Metric)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- c.Update(ctx, metrics)
+		close(metrics)
+	}()
+	for {
+		select {
+		case m, ok := <-metrics:
+			if !ok {
+				return <-errCh
+			}
+			if keep(m) {
+				ch <- m
+			}
+		case <-ctx.Done():
+			go func() {
+				for range metrics {
+				}
+			}()
+			if IsTimeoutError(ctx.Err()) {
+				return &TimeoutError{Err: ctx.Err()}
+			}
+			return ctx.Err()
+		}
 	}
-	ch <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, duration.Seconds(), name)
-	ch <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, success, name)
 }
 
 type typedDesc struct {
@@ -129,11 +452,3 @@ type typedDesc struct {
 func (d *typedDesc) mustNewConstMetric(value float64, labels ...string) prometheus.Metric {
 	return prometheus.MustNewConstMetric(d.desc, d.valueType, value, labels...)
 }
-
-// ErrNoData indicates the collector found no data to collect, but had no other error.
-var ErrNoData = errors.New("collector returned no data")
-
-// IsNoDataError defines the error of no data to collect
-func IsNoDataError(err error) bool {
-	return err == ErrNoData
-}
@@ -0,0 +1,98 @@
+package collector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+)
+
+var testDesc = prometheus.NewDesc("fs_test_value", "Test metric.", []string{"n"}, nil)
+
+// sleepCollector sends a metric after sleeping, unless its ctx is done first.
+type sleepCollector struct {
+	sleep time.Duration
+	// cancelled is closed when the ctx is done first, if not nil.
+	cancelled chan struct{}
+}
+
+func (c *sleepCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	select {
+	case <-time.After(c.sleep):
+	case <-ctx.Done():
+		if c.cancelled != nil {
+			close(c.cancelled)
+		}
+		return ctx.Err()
+	}
+	ch <- prometheus.MustNewConstMetric(testDesc, prometheus.GaugeValue, 1, "a")
+	return nil
+}
+
+// newTestFSCollector returns an FSCollector of the collectors, without
+// registering them.
+func newTestFSCollector(t *testing.T, collectors map[string]Collector) *FSCollector {
+	t.Helper()
+	filter, err := newMetricFilter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := zap.NewNop()
+	n := &FSCollector{
+		Collectors: collectors,
+		runs:       make(map[string]*collectorRun),
+		background: make(map[string]*backgroundCollector),
+		logger:     logger,
+	}
+	for name, c := range collectors {
+		n.runs[name] = &collectorRun{name: name, c: c, breaker: newBreaker(name, logger), filter: filter, logger: logger}
+	}
+	return n
+}
+
+// collectWithContext returns the metrics of a scrape bound to ctx.
+func collectWithContext(ctx context.Context, n *FSCollector) []prometheus.Metric {
+	ch := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		n.CollectWithContext(ctx, ch)
+		close(ch)
+	}()
+	var metrics []prometheus.Metric
+	go func() {
+		for m := range ch {
+			metrics = append(metrics, m)
+		}
+		close(done)
+	}()
+	<-done
+	return metrics
+}
+
+// gaugeValue returns the value of the metric of desc with the label values,
+// and whether it was found.
+func gaugeValue(t *testing.T, metrics []prometheus.Metric, desc *prometheus.Desc, labelValues ...string) (float64, bool) {
+	t.Helper()
+	for _, m := range metrics {
+		if m.Desc() != desc {
+			continue
+		}
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		match := len(pb.Label) == len(labelValues)
+		for i, lp := range pb.Label {
+			if match && lp.GetValue() != labelValues[i] {
+				match = false
+			}
+		}
+		if match {
+			return pb.GetGauge().GetValue(), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,196 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// The reasons of the collector errors.
+const (
+	reasonTimeout    = "timeout"
+	reasonExec       = "exec_failure"
+	reasonParse      = "parse_error"
+	reasonPermission = "permission_denied"
+	reasonNoData     = "no_data"
+	reasonUnknown    = "unknown"
+)
+
+// maxSnippetLen bounds the stderr and input kept in the errors.
+const maxSnippetLen = 256
+
+// ErrNoData indicates the collector found no data to collect, but had no other error.
+var ErrNoData = errors.New("collector returned no data")
+
+// errBreakerOpen indicates the collector was skipped because its circuit breaker is open.
+var errBreakerOpen = errors.New("collector circuit breaker is open")
+
+// ExecError indicates a command run by a collector failed.
+type ExecError struct {
+	Command  string
+	ExitCode int // -1 if the command didn't exit by itself
+	Stderr   string
+	Err      error
+}
+
+func (e *ExecError) Error() string {
+	msg := fmt.Sprintf("command %q failed with exit code %d: %v", e.Command, e.ExitCode, e.Err)
+	if e.Stderr != "" {
+		msg += ": " + e.Stderr
+	}
+	return msg
+}
+
+func (e *ExecError) Unwrap() error { return e.Err }
+
+// ParseError indicates a collector couldn't parse its input.
+type ParseError struct {
+	Input string // snippet of the offending input
+	Err   error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("failed to parse %q: %v", e.Input, e.Err)
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// TimeoutError indicates a collector was cancelled because it timed out.
+type TimeoutError struct {
+	Err error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("collector timed out: %v", e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error { return e.Err }
+
+// PermissionError indicates a collector isn't allowed to access a file or command.
+type PermissionError struct {
+	Path string
+	Err  error
+}
+
+func (e *PermissionError) Error() string {
+	return fmt.Sprintf("permission denied on %s: %v", e.Path, e.Err)
+}
+
+func (e *PermissionError) Unwrap() error { return e.Err }
+
+// NotInstalledError indicates the file system isn't installed on the host,
+// it is a no data state rather than a failure.
+type NotInstalledError struct {
+	Path string
+	Err  error
+}
+
+func (e *NotInstalledError) Error() string {
+	return fmt.Sprintf("%s is not installed: %v", e.Path, e.Err)
+}
+
+func (e *NotInstalledError) Unwrap() error { return e.Err }
+
+// Is makes errors.Is(err, ErrNoData) hold for a NotInstalledError.
+func (e *NotInstalledError) Is(target error) bool { return target == ErrNoData }
+
+// newExecError returns the typed error of a command which failed with err.
+func newExecError(command string, stderr []byte, err error) error {
+	// exec.Error wraps both, a binary which isn't executable is not
+	// a missing one.
+	if errors.Is(err, os.ErrPermission) {
+		return &PermissionError{Path: command, Err: err}
+	}
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, os.ErrNotExist) {
+		return &NotInstalledError{Path: command, Err: err}
+	}
+	if IsTimeoutError(err) {
+		return &TimeoutError{Err: err}
+	}
+	exitCode := -1
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitCode = exitErr.ExitCode()
+	}
+	return &ExecError{
+		Command:  command,
+		ExitCode: exitCode,
+		Stderr:   snippet(stderr),
+		Err:      err,
+	}
+}
+
+// newParseError returns the typed error of an input which failed to parse with err.
+func newParseError(input []byte, err error) error {
+	return &ParseError{Input: snippet(input), Err: err}
+}
+
+func snippet(b []byte) string {
+	s := strings.TrimSpace(string(b))
+	if len(s) > maxSnippetLen {
+		s = s[:maxSnippetLen] + "..."
+	}
+	return s
+}
+
+// IsNoDataError defines the error of no data to collect
+func IsNoDataError(err error) bool {
+	return errors.Is(err, ErrNoData)
+}
+
+// IsTimeoutError reports whether the collector was cancelled by its deadline.
+func IsTimeoutError(err error) bool {
+	var timeoutErr *TimeoutError
+	return errors.As(err, &timeoutErr) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// isCanceled reports whether the collector was cancelled because its scrape
+// was abandoned or the exporter is shutting down, which isn't a failure of
+// the collector.
+func isCanceled(err error) bool {
+	return errors.Is(err, context.Canceled) && !IsTimeoutError(err)
+}
+
+// errorReason classifies the error returned by a collector.
+func errorReason(err error) string {
+	var (
+		execErr       *ExecError
+		parseErr      *ParseError
+		permissionErr *PermissionError
+	)
+	switch {
+	case IsTimeoutError(err):
+		return reasonTimeout
+	case IsNoDataError(err):
+		return reasonNoData
+	case errors.As(err, &permissionErr), errors.Is(err, os.ErrPermission):
+		return reasonPermission
+	case errors.As(err, &execErr):
+		return reasonExec
+	case errors.As(err, &parseErr):
+		return reasonParse
+	default:
+		return reasonUnknown
+	}
+}
+
+// errorFields returns the details of the typed errors to log.
+func errorFields(err error) []zap.Field {
+	var (
+		execErr  *ExecError
+		parseErr *ParseError
+	)
+	switch {
+	case errors.As(err, &execErr):
+		return []zap.Field{zap.String("command", execErr.Command),
+			zap.Int("exit_code", execErr.ExitCode), zap.String("stderr", execErr.Stderr)}
+	case errors.As(err, &parseErr):
+		return []zap.Field{zap.String("input", parseErr.Input)}
+	}
+	return nil
+}
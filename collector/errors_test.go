@@ -0,0 +1,56 @@
+package collector
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewExecError(t *testing.T) {
+	dir := t.TempDir()
+	notExecutable := filepath.Join(dir, "gluster")
+	if err := ioutil.WriteFile(notExecutable, []byte("#!/bin/sh\nexit 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, permissionErr := exec.LookPath(notExecutable)
+	_, notFoundErr := exec.LookPath(filepath.Join(dir, "missing"))
+	_, notInPathErr := exec.LookPath("fs-exporter-missing-binary")
+	exitErr := exec.Command("sh", "-c", "exit 3").Run()
+
+	for _, tc := range []struct {
+		name   string
+		err    error
+		reason string
+		noData bool
+	}{
+		{"not executable", permissionErr, reasonPermission, false},
+		{"missing path", notFoundErr, reasonNoData, true},
+		{"not in PATH", notInPathErr, reasonNoData, true},
+		{"exit code", exitErr, reasonExec, false},
+		{"timeout", &TimeoutError{}, reasonTimeout, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.err == nil {
+				t.Fatal("no error to classify")
+			}
+			err := newExecError("gluster", []byte("boom"), tc.err)
+			if got := errorReason(err); got != tc.reason {
+				t.Errorf("reason of %v: got %s, want %s", err, got, tc.reason)
+			}
+			if got := IsNoDataError(err); got != tc.noData {
+				t.Errorf("IsNoDataError(%v): got %v, want %v", err, got, tc.noData)
+			}
+		})
+	}
+
+	var execErr *ExecError
+	if err := newExecError("sh", []byte("boom"), exitErr); !errors.As(err, &execErr) || execErr.ExitCode != 3 || execErr.Stderr != "boom" {
+		t.Errorf("got %#v, want an ExecError with exit code 3", err)
+	}
+	if !errors.Is(newExecError("gluster", nil, permissionErr), os.ErrPermission) {
+		t.Error("the PermissionError doesn't wrap os.ErrPermission")
+	}
+}
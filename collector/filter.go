@@ -0,0 +1,127 @@
+package collector
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/spf13/pflag"
+)
+
+// metric filter parameters
+var (
+	metricAllow      string
+	metricDeny       string
+	labelValueDenies []string
+)
+
+var scrapeSeriesDroppedDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "scrape", "collector_series_dropped"),
+	"Number of series a collector exposed beyond its series limit, which were dropped.",
+	[]string{"collector"},
+	nil,
+)
+
+func addFilterFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&metricAllow, "metrics.allow", "",
+		"Regexp of the metric names to expose, the others are dropped. Empty to expose all the metrics.")
+	flags.StringVar(&metricDeny, "metrics.deny", "",
+		"Regexp of the metric names to drop.")
+	flags.StringArrayVar(&labelValueDenies, "metrics.deny-label-value", nil,
+		"Drop the series whose label value matches the regexp, as label=regexp. Repeat it for more labels.")
+}
+
+// metricFilter drops the metrics of the collectors by name and label value.
+type metricFilter struct {
+	allow  *regexp.Regexp
+	deny   *regexp.Regexp
+	labels map[string]*regexp.Regexp
+}
+
+// newMetricFilter returns the filter configured by the metrics flags,
+// the regexps are anchored like the ones of Prometheus relabeling.
+func newMetricFilter() (*metricFilter, error) {
+	f := &metricFilter{labels: make(map[string]*regexp.Regexp)}
+	var err error
+	if metricAllow != "" {
+		if f.allow, err = compileAnchored(metricAllow); err != nil {
+			return nil, fmt.Errorf("invalid --metrics.allow: %s", err)
+		}
+	}
+	if metricDeny != "" {
+		if f.deny, err = compileAnchored(metricDeny); err != nil {
+			return nil, fmt.Errorf("invalid --metrics.deny: %s", err)
+		}
+	}
+	for _, l := range labelValueDenies {
+		kv := strings.SplitN(l, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid --metrics.deny-label-value %q, expected label=regexp", l)
+		}
+		if f.labels[kv[0]], err = compileAnchored(kv[1]); err != nil {
+			return nil, fmt.Errorf("invalid --metrics.deny-label-value %q: %s", l, err)
+		}
+	}
+	return f, nil
+}
+
+func compileAnchored(expr string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + expr + ")$")
+}
+
+// keep reports whether the metric passes the filter.
+func (f *metricFilter) keep(m prometheus.Metric) bool {
+	if f.allow == nil && f.deny == nil && len(f.labels) == 0 {
+		return true
+	}
+	name := metricName(m.Desc())
+	if f.allow != nil && !f.allow.MatchString(name) {
+		return false
+	}
+	if f.deny != nil && f.deny.MatchString(name) {
+		return false
+	}
+	if len(f.labels) == 0 {
+		return true
+	}
+	var pb dto.Metric
+	if err := m.Write(&pb); err != nil {
+		return true
+	}
+	for _, lp := range pb.GetLabel() {
+		if re, ok := f.labels[lp.GetName()]; ok && re.MatchString(lp.GetValue()) {
+			return false
+		}
+	}
+	return true
+}
+
+// descNames caches the fully-qualified names of the descs of the collectors,
+// which prometheus.Desc doesn't expose.
+var descNames sync.Map
+
+// metricName returns the fully-qualified name of the desc, parsed once from
+// its String method.
+func metricName(desc *prometheus.Desc) string {
+	if name, ok := descNames.Load(desc); ok {
+		return name.(string)
+	}
+	name := parseDescName(desc.String())
+	descNames.Store(desc, name)
+	return name
+}
+
+func parseDescName(s string) string {
+	const prefix = `Desc{fqName: "`
+	if !strings.HasPrefix(s, prefix) {
+		return ""
+	}
+	s = s[len(prefix):]
+	if i := strings.IndexByte(s, '"'); i >= 0 {
+		return s[:i]
+	}
+	return ""
+}
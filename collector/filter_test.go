@@ -0,0 +1,154 @@
+package collector
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+)
+
+var (
+	filterTestUsedDesc  = prometheus.NewDesc("fs_test_used_bytes", "Used bytes.", []string{"volume"}, nil)
+	filterTestSizeDesc  = prometheus.NewDesc("fs_test_size_bytes", "Size bytes.", []string{"volume"}, nil)
+	filterTestOtherDesc = prometheus.NewDesc("fs_test_other", "Other.", []string{"volume"}, nil)
+)
+
+// volumesCollector exposes the metrics of a few volumes.
+type volumesCollector struct{}
+
+func (volumesCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	for _, volume := range []string{"data", "logs", "tmp"} {
+		ch <- prometheus.MustNewConstMetric(filterTestUsedDesc, prometheus.GaugeValue, 1, volume)
+		ch <- prometheus.MustNewConstMetric(filterTestSizeDesc, prometheus.GaugeValue, 2, volume)
+		ch <- prometheus.MustNewConstMetric(filterTestOtherDesc, prometheus.GaugeValue, 3, volume)
+	}
+	return nil
+}
+
+// withFilterFlags sets the metric filter flags for the duration of the test.
+func withFilterFlags(t *testing.T, allow, deny string, labelValues ...string) {
+	t.Helper()
+	oldAllow, oldDeny, oldLabelValues := metricAllow, metricDeny, labelValueDenies
+	t.Cleanup(func() { metricAllow, metricDeny, labelValueDenies = oldAllow, oldDeny, oldLabelValues })
+	metricAllow, metricDeny, labelValueDenies = allow, deny, labelValues
+}
+
+// runVolumes runs the volumes collector once with the filter flags and the
+// series limit, and returns its series as name{volume}.
+func runVolumes(t *testing.T, seriesLimit int) ([]string, int) {
+	t.Helper()
+	filter, err := newMetricFilter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := zap.NewNop()
+	r := &collectorRun{
+		name:        "volumes",
+		c:           volumesCollector{},
+		breaker:     newBreaker("volumes", logger),
+		filter:      filter,
+		seriesLimit: seriesLimit,
+		logger:      logger,
+	}
+	ch := make(chan prometheus.Metric)
+	var series []string
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for m := range ch {
+			var pb dto.Metric
+			if err := m.Write(&pb); err != nil {
+				t.Error(err)
+				continue
+			}
+			series = append(series, metricName(m.Desc())+"{"+pb.GetLabel()[0].GetValue()+"}")
+		}
+	}()
+	_, dropped, err := r.run(context.Background(), ch)
+	close(ch)
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(series)
+	return series, dropped
+}
+
+func TestMetricFilter(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		allow, deny string
+		labelValues []string
+		want        []string
+	}{
+		{
+			name: "no filter",
+			want: []string{
+				"fs_test_other{data}", "fs_test_other{logs}", "fs_test_other{tmp}",
+				"fs_test_size_bytes{data}", "fs_test_size_bytes{logs}", "fs_test_size_bytes{tmp}",
+				"fs_test_used_bytes{data}", "fs_test_used_bytes{logs}", "fs_test_used_bytes{tmp}",
+			},
+		},
+		{
+			name:  "allow",
+			allow: "fs_test_used_bytes|fs_test_other",
+			want: []string{
+				"fs_test_other{data}", "fs_test_other{logs}", "fs_test_other{tmp}",
+				"fs_test_used_bytes{data}", "fs_test_used_bytes{logs}", "fs_test_used_bytes{tmp}",
+			},
+		},
+		{
+			// The regexps are anchored, fs_test doesn't match any name.
+			name:  "allow anchored",
+			allow: "fs_test",
+		},
+		{
+			name:  "allow and deny",
+			allow: "fs_test_.*_bytes",
+			deny:  "fs_test_size_.*",
+			want:  []string{"fs_test_used_bytes{data}", "fs_test_used_bytes{logs}", "fs_test_used_bytes{tmp}"},
+		},
+		{
+			name:        "label value",
+			deny:        "fs_test_other",
+			labelValues: []string{"volume=tmp|logs"},
+			want:        []string{"fs_test_size_bytes{data}", "fs_test_used_bytes{data}"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			withFilterFlags(t, tc.allow, tc.deny, tc.labelValues...)
+			got, dropped := runVolumes(t, 0)
+			if strings.Join(got, " ") != strings.Join(tc.want, " ") {
+				t.Errorf("got series %v, want %v", got, tc.want)
+			}
+			if dropped != 0 {
+				t.Errorf("got %d dropped series, want 0", dropped)
+			}
+		})
+	}
+}
+
+func TestSeriesLimit(t *testing.T) {
+	// The series dropped by the filter don't count towards the limit.
+	withFilterFlags(t, "", "fs_test_other")
+	got, dropped := runVolumes(t, 4)
+	if len(got) != 4 {
+		t.Errorf("got series %v, want 4 of them", got)
+	}
+	if dropped != 2 {
+		t.Errorf("got %d dropped series, want 2", dropped)
+	}
+}
+
+func TestInvalidMetricFilter(t *testing.T) {
+	for _, flags := range [][]string{{"(", ""}, {"", "("}, {"", "", "volume"}, {"", "", "volume=("}} {
+		withFilterFlags(t, flags[0], flags[1], flags[2:]...)
+		if _, err := newMetricFilter(); err == nil {
+			t.Errorf("%q: got no error", flags)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package collector
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var scrapesCoalesced = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Subsystem: "scrape",
+	Name:      "coalesced_total",
+	Help:      "Total number of scrapes which were served by a collection of the same collectors already in flight.",
+})
+
+// flight is a collection in progress, shared by the concurrent scrapes of the same collectors.
+type flight struct {
+	done chan struct{}
+
+	// results are the metrics of the collectors which returned, keyed by
+	// collector and guarded by mtx.
+	mtx     sync.Mutex
+	results map[string][]prometheus.Metric
+
+	// waiters is the number of scrapes waiting for the collection, guarded
+	// by flightMutex. The collection ends once they all gave up.
+	waiters int
+	ctx     *flightContext
+}
+
+// flightContext is the context of a flight. It is done on shutdown, or once
+// all the scrapes waiting for the flight gave up, with the error of the last
+// of them: the collectors still running are reported as timed out when that
+// scrape reached its deadline, and as cancelled only on a client disconnect.
+type flightContext struct {
+	parent context.Context
+	done   chan struct{}
+
+	mtx sync.Mutex
+	err error
+}
+
+func newFlightContext(parent context.Context) *flightContext {
+	c := &flightContext{parent: parent, done: make(chan struct{})}
+	go func() {
+		select {
+		case <-parent.Done():
+			c.cancel(parent.Err())
+		case <-c.done:
+		}
+	}()
+	return c
+}
+
+func (c *flightContext) cancel(err error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.err == nil {
+		c.err = err
+		close(c.done)
+	}
+}
+
+func (c *flightContext) Deadline() (time.Time, bool) { return c.parent.Deadline() }
+
+func (c *flightContext) Done() <-chan struct{} { return c.done }
+
+func (c *flightContext) Err() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.err
+}
+
+func (c *flightContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+var (
+	flightMutex sync.Mutex
+	flights     = make(map[string]*flight)
+)
+
+// flightKey identifies the set of collectors of the FSCollector.
+func (n *FSCollector) flightKey() string {
+	names := make([]string, 0, len(n.Collectors))
+	for name := range n.Collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// coalesce runs the collectors unless they are already being collected, in
+// which case it joins that collection. The collection doesn't belong to any
+// of the scrapes: it is bounded by the collector timeouts, and ends on
+// shutdown or once all the scrapes waiting for it gave up, so by the latest
+// of their deadlines. Each scrape waits
+// until its own ctx is done, coalesce returns the metrics of the collectors
+// which returned by then, keyed by collector.
+func (n *FSCollector) coalesce(ctx context.Context) map[string][]prometheus.Metric {
+	key := n.flightKey()
+
+	flightMutex.Lock()
+	f, ok := flights[key]
+	if ok {
+		scrapesCoalesced.Inc()
+	} else {
+		f = &flight{
+			done:    make(chan struct{}),
+			results: make(map[string][]prometheus.Metric, len(n.Collectors)),
+			ctx:     newFlightContext(backgroundCtx),
+		}
+		flights[key] = f
+		go f.run(key, n)
+	}
+	f.waiters++
+	flightMutex.Unlock()
+
+	select {
+	case <-f.done:
+		return f.results
+	case <-ctx.Done():
+		flightMutex.Lock()
+		if f.waiters--; f.waiters == 0 {
+			// Nobody is left to serve, the next scrape starts a new collection.
+			f.ctx.cancel(ctx.Err())
+			if flights[key] == f {
+				delete(flights, key)
+			}
+		}
+		flightMutex.Unlock()
+		return f.collected()
+	}
+}
+
+// collected returns the results of the collectors which returned so far.
+func (f *flight) collected() map[string][]prometheus.Metric {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	results := make(map[string][]prometheus.Metric, len(f.results))
+	for name, metrics := range f.results {
+		results[name] = metrics
+	}
+	return results
+}
+
+func (f *flight) run(key string, n *FSCollector) {
+	defer f.ctx.cancel(context.Canceled)
+	var wg sync.WaitGroup
+	wg.Add(len(n.Collectors))
+	for name, c := range n.Collectors {
+		go func(name string, c Collector) {
+			defer wg.Done()
+			metrics := make(chan prometheus.Metric)
+			collected := make(chan []prometheus.Metric)
+			go func() {
+				var ms []prometheus.Metric
+				for m := range metrics {
+					ms = append(ms, m)
+				}
+				collected <- ms
+			}()
+			n.execute(f.ctx, name, c, metrics)
+			close(metrics)
+			ms := <-collected
+
+			f.mtx.Lock()
+			f.results[name] = ms
+			f.mtx.Unlock()
+		}(name, c)
+	}
+	wg.Wait()
+
+	flightMutex.Lock()
+	if flights[key] == f {
+		delete(flights, key)
+	}
+	flightMutex.Unlock()
+	close(f.done)
+}
@@ -0,0 +1,180 @@
+package collector
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCoalesceDoesNotInheritTheFirstDeadline(t *testing.T) {
+	n := newTestFSCollector(t, map[string]Collector{"slow": &sleepCollector{sleep: 200 * time.Millisecond}})
+
+	short, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var (
+		wg      sync.WaitGroup
+		first   = make(chan struct{})
+		results = make([][]interface{}, 3)
+	)
+	scrape := func(i int, ctx context.Context) {
+		defer wg.Done()
+		metrics := collectWithContext(ctx, n)
+		success, ok := gaugeValue(t, metrics, scrapeSuccessDesc, "slow")
+		timedOut, _ := gaugeValue(t, metrics, scrapeTimeoutDesc, "slow")
+		_, value := gaugeValue(t, metrics, testDesc, "a")
+		results[i] = []interface{}{ok, success, timedOut, value}
+	}
+	wg.Add(3)
+	go func() {
+		close(first)
+		scrape(0, short)
+	}()
+	<-first
+	time.Sleep(10 * time.Millisecond)
+	go scrape(1, context.Background())
+	go scrape(2, context.Background())
+	wg.Wait()
+
+	// The scrape with the short deadline gives up on its own.
+	if want := []interface{}{true, 0.0, 1.0, false}; !equal(results[0], want) {
+		t.Errorf("first scrape: got success, value %v, want %v", results[0], want)
+	}
+	for _, i := range []int{1, 2} {
+		if want := []interface{}{true, 1.0, 0.0, true}; !equal(results[i], want) {
+			t.Errorf("scrape %d: got %v, want %v", i, results[i], want)
+		}
+	}
+	if err := n.runs["slow"].lastErr; err != nil {
+		t.Errorf("the shared collection failed: %v", err)
+	}
+}
+
+func TestCoalesceKeepsTheCollectorsWhichReturned(t *testing.T) {
+	n := newTestFSCollector(t, map[string]Collector{
+		"fast": &sleepCollector{sleep: time.Millisecond},
+		"hung": &sleepCollector{sleep: time.Hour},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	metrics := collectWithContext(ctx, n)
+
+	// Only the collector still running when the scrape gave up timed out.
+	for _, tc := range []struct {
+		name              string
+		success, timedOut float64
+	}{
+		{"fast", 1, 0},
+		{"hung", 0, 1},
+	} {
+		success, _ := gaugeValue(t, metrics, scrapeSuccessDesc, tc.name)
+		timedOut, _ := gaugeValue(t, metrics, scrapeTimeoutDesc, tc.name)
+		if success != tc.success || timedOut != tc.timedOut {
+			t.Errorf("%s: got success %v, timeout %v, want %v, %v", tc.name, success, timedOut, tc.success, tc.timedOut)
+		}
+	}
+	if _, ok := gaugeValue(t, metrics, testDesc, "a"); !ok {
+		t.Error("the metric of the collector which returned is missing")
+	}
+}
+
+// lastErr waits until the collector ran, and returns its error.
+func lastErr(t *testing.T, r *collectorRun) error {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		r.mtx.Lock()
+		lastRun, err := r.lastRun, r.lastErr
+		r.mtx.Unlock()
+		if !lastRun.IsZero() {
+			return err
+		}
+	}
+	t.Fatal("the collector never ran")
+	return nil
+}
+
+func TestCoalesceEndsTheCollectionOnceAllScrapesTimedOut(t *testing.T) {
+	c := &sleepCollector{sleep: time.Hour, cancelled: make(chan struct{})}
+	n := newTestFSCollector(t, map[string]Collector{"slow": c})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	collectWithContext(ctx, n)
+
+	select {
+	case <-c.cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("the collection wasn't cancelled")
+	}
+	// The collection reached the deadline of the scrape.
+	if err := lastErr(t, n.runs["slow"]); !IsTimeoutError(err) {
+		t.Errorf("got error %v, want a timeout", err)
+	}
+	flightMutex.Lock()
+	defer flightMutex.Unlock()
+	if len(flights) != 0 {
+		t.Errorf("%d collections still in flight", len(flights))
+	}
+}
+
+func TestCoalesceTimeoutsOpenTheBreaker(t *testing.T) {
+	n := newTestFSCollector(t, map[string]Collector{"slow": &sleepCollector{sleep: time.Hour}})
+	b := n.runs["slow"].breaker
+	b.threshold, b.backoff, b.maxBackoff = 2, time.Hour, time.Hour
+
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		collectWithContext(ctx, n)
+		cancel()
+		// Wait for the collection to end, the next scrape starts another one.
+		for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+			b.mtx.Lock()
+			failures := b.failures
+			b.mtx.Unlock()
+			if failures == i+1 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("got %d failures after %d scrapes", failures, i+1)
+			}
+		}
+	}
+	if state, _ := b.state(); state != BreakerOpen {
+		t.Errorf("got breaker %s, want open", state)
+	}
+}
+
+func TestCoalesceIgnoresDisconnectedScrapes(t *testing.T) {
+	c := &sleepCollector{sleep: time.Hour, cancelled: make(chan struct{})}
+	n := newTestFSCollector(t, map[string]Collector{"slow": c})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	collectWithContext(ctx, n)
+
+	select {
+	case <-c.cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("the collection wasn't cancelled")
+	}
+	time.Sleep(10 * time.Millisecond)
+	r := n.runs["slow"]
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if !r.lastRun.IsZero() {
+		t.Errorf("got a run ending with %v, want the cancelled run ignored", r.lastErr)
+	}
+}
+
+func equal(a, b []interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
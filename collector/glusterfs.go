@@ -1,6 +1,7 @@
 package collector
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -23,11 +24,12 @@ var (
 
 // GlusterfsCollector defines structure of glusterfs stats
 type GlusterfsCollector struct {
+	runner Runner
 	logger *zap.Logger
 }
 
 // Update implements Collector.Update
-func (c *GlusterfsCollector) Update(ch chan<- prometheus.Metric) error {
+func (c *GlusterfsCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
 	c.logger.Debug("gluster options", zap.String("gluster.executable-path", glusterExecPath),
 		zap.Any("gluster.volumes", glusterVolumes),
 		zap.Bool("gluster.profile", glusterProfile),
@@ -37,9 +39,20 @@ func (c *GlusterfsCollector) Update(ch chan<- prometheus.Metric) error {
 
 // NewGlusterfsCollector returns a new Collector exposing glusterfs stats.
 func NewGlusterfsCollector(logger *zap.Logger) (Collector, error) {
+	runner, err := newRunner("glusterfs", logger)
+	if err != nil {
+		return nil, err
+	}
+	return NewGlusterfsCollectorWithRunner(runner, logger), nil
+}
+
+// NewGlusterfsCollectorWithRunner returns a new Collector exposing glusterfs stats,
+// which runs its commands with runner, e.g. a fake one in tests.
+func NewGlusterfsCollectorWithRunner(runner Runner, logger *zap.Logger) Collector {
 	return &GlusterfsCollector{
+		runner: runner,
 		logger: logger,
-	}, nil
+	}
 }
 
 func AddGlusterFlags(flags *pflag.FlagSet) {
@@ -50,5 +63,5 @@ func AddGlusterFlags(flags *pflag.FlagSet) {
 }
 
 func init() {
-	registerCollector("glusterfs", NewGlusterfsCollector)
+	registerCollector("glusterfs", defaultEnabled, NewGlusterfsCollector)
 }
@@ -0,0 +1,117 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// HostIdentity identifies the host by its hostname, machine-id and os-release.
+type HostIdentity struct {
+	Hostname  string
+	MachineID string
+	// OSRelease holds the KEY=value pairs of os-release(5).
+	OSRelease map[string]string
+}
+
+// ReadHostIdentity reads the identity of the host, through --path.rootfs.
+func ReadHostIdentity(logger *zap.Logger) HostIdentity {
+	host := HostIdentity{Hostname: hostname(logger)}
+	if id, err := ioutil.ReadFile(rootfsFilePath("etc/machine-id")); err != nil {
+		logger.Warn("failed to read machine-id", zap.Error(err))
+	} else {
+		host.MachineID = strings.TrimSpace(string(id))
+	}
+	host.OSRelease = readOSRelease(logger)
+	return host
+}
+
+// NewHostInfoCollector returns a collector of the fs_exporter_host_info metric,
+// which identifies the host by its hostname, machine-id and os-release.
+// They are read once, through --path.rootfs.
+func NewHostInfoCollector(logger *zap.Logger) prometheus.Collector {
+	host := ReadHostIdentity(logger)
+	labels := prometheus.Labels{
+		"hostname":   host.Hostname,
+		"machine_id": host.MachineID,
+	}
+	for label, key := range map[string]string{
+		"os_id":          "ID",
+		"os_name":        "NAME",
+		"os_version_id":  "VERSION_ID",
+		"os_pretty_name": "PRETTY_NAME",
+	} {
+		labels[label] = host.OSRelease[key]
+	}
+
+	info := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Subsystem:   "exporter",
+		Name:        "host_info",
+		Help:        "Identity of the host, from its hostname, machine-id and os-release.",
+		ConstLabels: labels,
+	})
+	info.Set(1)
+	return info
+}
+
+// hostname prefers the hostname of the host to the one of the container.
+func hostname(logger *zap.Logger) string {
+	if rootfsPath != "/" {
+		if name, err := ioutil.ReadFile(rootfsFilePath("etc/hostname")); err == nil {
+			return strings.TrimSpace(string(name))
+		}
+	}
+	name, err := os.Hostname()
+	if err != nil {
+		logger.Warn("failed to get hostname", zap.Error(err))
+	}
+	return name
+}
+
+func readOSRelease(logger *zap.Logger) map[string]string {
+	var (
+		data []byte
+		err  error
+	)
+	for _, name := range []string{"etc/os-release", "usr/lib/os-release"} {
+		if data, err = ioutil.ReadFile(rootfsFilePath(name)); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		logger.Warn("failed to read os-release", zap.Error(err))
+		return nil
+	}
+	return parseOSRelease(data)
+}
+
+// parseOSRelease parses the KEY=value lines of os-release(5).
+func parseOSRelease(data []byte) map[string]string {
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		value := kv[1]
+		if v, err := strconv.Unquote(value); err == nil {
+			value = v
+		} else {
+			value = strings.Trim(value, `"'`)
+		}
+		values[kv[0]] = value
+	}
+	return values
+}
@@ -0,0 +1,30 @@
+package collector
+
+import (
+	"path/filepath"
+
+	"github.com/spf13/pflag"
+)
+
+// mountpoints of the host file systems, they differ from the defaults when
+// the exporter runs in a container with the host mounted, e.g. at /host.
+var (
+	procPath   string
+	rootfsPath string
+)
+
+// AddPathFlags adds the flags of the host mountpoints.
+func AddPathFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&procPath, "path.procfs", "/proc", "procfs mountpoint.")
+	flags.StringVar(&rootfsPath, "path.rootfs", "/", "rootfs mountpoint.")
+}
+
+// procFilePath returns the path of the file under procfs, e.g. spl/kstat/zfs/arcstats.
+func procFilePath(name string) string {
+	return filepath.Join(procPath, name)
+}
+
+// rootfsFilePath returns the path of the file under the host root, e.g. etc/fstab.
+func rootfsFilePath(name string) string {
+	return filepath.Join(rootfsPath, name)
+}
@@ -0,0 +1,121 @@
+package collector
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// The kinds of the recorded errors which aren't a plain exit code.
+const (
+	recordNotInstalled = "not_installed"
+	recordPermission   = "permission_denied"
+	recordOutputLimit  = "output_limit"
+)
+
+// execRecord is the outcome of a command, as saved by --exec.record-dir.
+type execRecord struct {
+	Command  string   `json:"command"`
+	Args     []string `json:"args"`
+	Stdout   string   `json:"stdout"`
+	Stderr   string   `json:"stderr"`
+	ExitCode int      `json:"exit_code"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// recordFile returns the file of the command in dir, it is named after the
+// command and a hash of its arguments so that every invocation has its own.
+func recordFile(dir, name string, args []string) string {
+	h := sha256.Sum256([]byte(strings.Join(append([]string{name}, args...), "\x00")))
+	return filepath.Join(dir, fmt.Sprintf("%s-%s.json", filepath.Base(name), hex.EncodeToString(h[:8])))
+}
+
+// record saves the outcome of a command run by the execRunner, with its whole
+// stdout and stderr, err is the error returned by Run.
+func (r *execRunner) record(name string, args []string, stdout, stderr []byte, err error) {
+	rec := execRecord{
+		Command: name,
+		Args:    args,
+		Stdout:  string(stdout),
+		Stderr:  string(stderr),
+	}
+	var (
+		execErr       *ExecError
+		notInstalled  *NotInstalledError
+		permissionErr *PermissionError
+	)
+	switch {
+	case err == nil:
+	case errors.As(err, &notInstalled):
+		rec.Error = recordNotInstalled
+	case errors.As(err, &permissionErr):
+		rec.Error = recordPermission
+	case errors.Is(err, errOutputLimit):
+		rec.Error = recordOutputLimit
+	case errors.As(err, &execErr):
+		rec.ExitCode = execErr.ExitCode
+	default:
+		return
+	}
+
+	file := recordFile(r.recordDir, name, args)
+	data, merr := json.MarshalIndent(&rec, "", "  ")
+	if merr == nil {
+		merr = ioutil.WriteFile(file, data, 0644)
+	}
+	if merr != nil {
+		r.logger.Warn("failed to record command", zap.String("command", name), zap.String("file", file), zap.Error(merr))
+	} else {
+		r.logger.Debug("recorded command", zap.String("command", name), zap.String("file", file))
+	}
+}
+
+// replayRunner serves the outcomes saved by --exec.record-dir instead of running the commands.
+type replayRunner struct {
+	dir    string
+	logger *zap.Logger
+}
+
+// Run implements Runner.Run.
+func (r *replayRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	file := recordFile(r.dir, name, args)
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// The command wasn't run on the recorded host.
+			r.logger.Debug("no recorded command", zap.String("command", name), zap.String("file", file))
+			return nil, &NotInstalledError{Path: name, Err: err}
+		}
+		return nil, err
+	}
+	var rec execRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, newParseError(data, err)
+	}
+
+	switch {
+	case rec.Error == recordNotInstalled:
+		return nil, &NotInstalledError{Path: name, Err: errors.New("recorded as not installed")}
+	case rec.Error == recordPermission:
+		return nil, &PermissionError{Path: name, Err: os.ErrPermission}
+	case rec.Error == recordOutputLimit:
+		return nil, newExecError(name, []byte(rec.Stderr), errOutputLimit)
+	case rec.ExitCode != 0:
+		return nil, &ExecError{
+			Command:  name,
+			ExitCode: rec.ExitCode,
+			Stderr:   snippet([]byte(rec.Stderr)),
+			Err:      fmt.Errorf("recorded exit status %d", rec.ExitCode),
+		}
+	}
+	return []byte(rec.Stdout), nil
+}
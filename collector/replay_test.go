@@ -0,0 +1,70 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestRecordReplay(t *testing.T) {
+	dir := t.TempDir()
+	recorder := newTestExecRunner(0)
+	recorder.recordDir = dir
+	replayer := &replayRunner{dir: dir, logger: zap.NewNop()}
+
+	long := strings.Repeat("e", 1000)
+	commands := [][]string{
+		{"sh", "-c", "echo out; echo warning >&2"},
+		{"sh", "-c", "echo partial; printf " + long + " >&2; exit 3"},
+		{"/nonexistent/command"},
+	}
+	for _, c := range commands {
+		wantOut, wantErr := recorder.Run(context.Background(), c[0], c[1:]...)
+		gotOut, gotErr := replayer.Run(context.Background(), c[0], c[1:]...)
+		if string(gotOut) != string(wantOut) {
+			t.Errorf("%v: replayed stdout %q, want %q", c, gotOut, wantOut)
+		}
+		var gotNotInstalled, wantNotInstalled *NotInstalledError
+		if (gotErr == nil) != (wantErr == nil) ||
+			errors.As(gotErr, &gotNotInstalled) != errors.As(wantErr, &wantNotInstalled) {
+			t.Errorf("%v: replayed error %v, want %v", c, gotErr, wantErr)
+		}
+		var gotExec, wantExec *ExecError
+		if errors.As(wantErr, &wantExec) {
+			if !errors.As(gotErr, &gotExec) || gotExec.ExitCode != wantExec.ExitCode || gotExec.Stderr != wantExec.Stderr {
+				t.Errorf("%v: replayed error %#v, want %#v", c, gotErr, wantErr)
+			}
+		}
+	}
+
+	// The record keeps the whole output, even of the failed commands.
+	data, err := ioutil.ReadFile(recordFile(dir, commands[1][0], commands[1][1:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec execRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		t.Fatal(err)
+	}
+	want := execRecord{Command: "sh", Args: commands[1][1:], Stdout: "partial\n", Stderr: long, ExitCode: 3}
+	if !reflect.DeepEqual(rec, want) {
+		t.Errorf("got record %+v, want %+v", rec, want)
+	}
+
+	data, err = ioutil.ReadFile(recordFile(dir, commands[0][0], commands[0][1:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Stderr != "warning\n" {
+		t.Errorf("got recorded stderr %q, want %q", rec.Stderr, "warning\n")
+	}
+}
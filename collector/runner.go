@@ -0,0 +1,278 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+)
+
+// exec parameters
+var (
+	execMaxOutputBytes int
+	execNice           int
+	execIoniceClass    string
+	execRecordDir      string
+	execReplayDir      string
+)
+
+// sanitizedEnv is the environment of the commands, so that their output
+// doesn't depend on the locale or the environment of the exporter.
+var sanitizedEnv = []string{
+	"LC_ALL=C",
+	"LANG=C",
+	"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+}
+
+var execDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Subsystem: "exec",
+	Name:      "command_duration_seconds",
+	Help:      "Duration of the commands run by the collectors.",
+	Buckets:   []float64{.01, .05, .1, .5, 1, 5, 10, 30, 60, 300},
+}, []string{"command", "result"})
+
+// The modes to run the commands of a collector.
+const (
+	execModeHost    = "host"
+	execModeNsenter = "nsenter"
+	execModeChroot  = "chroot"
+)
+
+// errOutputLimit indicates a command wrote more than --exec.max-output-bytes.
+var errOutputLimit = errors.New("command output exceeded the limit")
+
+// Runner runs the external commands of the collectors, it returns the stdout
+// of the command. Collectors are given a Runner so that they can be tested
+// with a fake one instead of the real binaries.
+type Runner interface {
+	Run(ctx context.Context, name string, args ...string) ([]byte, error)
+}
+
+// AddExecFlags adds the flags of the commands run by the collectors.
+func AddExecFlags(flags *pflag.FlagSet) {
+	flags.IntVar(&execMaxOutputBytes, "exec.max-output-bytes", 16<<20,
+		"Maximum number of bytes read from the stdout and stderr of a command. Use 0 for no limit.")
+	flags.IntVar(&execNice, "exec.nice", 0, "Niceness adjustment of the commands, see nice(1). Use 0 to run them unchanged.")
+	flags.StringVar(&execIoniceClass, "exec.ionice-class", "",
+		"I/O scheduling class of the commands, see ionice(1). One of (idle|best-effort|realtime), empty to run them unchanged.")
+	flags.StringVar(&execRecordDir, "exec.record-dir", "",
+		"Directory to save the stdout, stderr and exit code of every command run by the collectors.")
+	flags.StringVar(&execReplayDir, "exec.replay-dir", "",
+		"Directory of the commands saved by --exec.record-dir, to serve instead of running the commands.")
+}
+
+// newRunner returns the Runner of the collector configured by the exec flags.
+func newRunner(name string, logger *zap.Logger) (Runner, error) {
+	if execRecordDir != "" && execReplayDir != "" {
+		return nil, fmt.Errorf("--exec.record-dir and --exec.replay-dir are mutually exclusive")
+	}
+	if execReplayDir != "" {
+		return &replayRunner{dir: execReplayDir, logger: logger}, nil
+	}
+	mode := execModeHost
+	if m, ok := collectorExecModes[name]; ok {
+		mode = *m
+	}
+	switch mode {
+	case execModeHost, execModeNsenter, execModeChroot:
+	default:
+		return nil, fmt.Errorf("unknown exec mode of the %s collector: %s", name, mode)
+	}
+	if execRecordDir != "" {
+		if err := os.MkdirAll(execRecordDir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	r := &execRunner{
+		mode:           mode,
+		maxOutputBytes: execMaxOutputBytes,
+		nice:           execNice,
+		ioniceClass:    execIoniceClass,
+		recordDir:      execRecordDir,
+		logger:         logger,
+	}
+	if err := r.lookWrappers(); err != nil {
+		return nil, fmt.Errorf("%v, required by the %s collector", err, name)
+	}
+	return r, nil
+}
+
+// execRunner runs the commands on the host. A command is killed along with
+// its process group when the ctx is done.
+//
+// When the exporter runs in a container, the nsenter mode runs the commands
+// in the mount namespace of the host's init process, and the chroot mode in
+// --path.rootfs, so that the host's binaries are used.
+type execRunner struct {
+	mode           string
+	maxOutputBytes int
+	nice           int
+	ioniceClass    string
+	// recordDir is the directory to save the outcome of the commands to, if any.
+	recordDir string
+	logger    *zap.Logger
+}
+
+// Run implements Runner.Run.
+func (r *execRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	stdout, stderr, err := r.exec(ctx, name, args)
+	// A cancelled command doesn't reflect the state of the host.
+	if r.recordDir != "" && ctx.Err() == nil {
+		r.record(name, args, stdout, stderr, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stdout, nil
+}
+
+// exec runs the command, and returns its stdout and stderr along with the
+// typed error of its failure.
+func (r *execRunner) exec(ctx context.Context, name string, args []string) ([]byte, []byte, error) {
+	// Look the command up before it is wrapped, nice and ionice would hide
+	// that it isn't installed.
+	if err := r.lookPath(name); err != nil {
+		return nil, nil, newExecError(name, nil, err)
+	}
+	path, argv := r.command(name, args)
+	cmd := exec.Command(path, argv...)
+	cmd.Env = sanitizedEnv
+	stdout := &limitedBuffer{limit: r.maxOutputBytes}
+	stderr := &limitedBuffer{limit: r.maxOutputBytes}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	setProcessGroup(cmd)
+
+	r.logger.Debug("running command", zap.String("command", path), zap.Strings("args", argv))
+	begin := time.Now()
+	err := r.run(ctx, cmd)
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	execDuration.WithLabelValues(filepath.Base(name), result).Observe(time.Since(begin).Seconds())
+
+	if err != nil {
+		if ctx.Err() != nil {
+			err = fmt.Errorf("%v: %w", err, ctx.Err())
+		}
+		return stdout.Bytes(), stderr.Bytes(), newExecError(name, stderr.Bytes(), err)
+	}
+	if stdout.truncated || stderr.truncated {
+		return stdout.Bytes(), stderr.Bytes(), newExecError(name, stderr.Bytes(), errOutputLimit)
+	}
+	return stdout.Bytes(), stderr.Bytes(), nil
+}
+
+func (r *execRunner) run(ctx context.Context, cmd *exec.Cmd) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			killProcessGroup(cmd)
+		case <-done:
+		}
+	}()
+	return cmd.Wait()
+}
+
+// lookPath checks that the command is installed where it is run. Only the
+// absolute paths are checked in the nsenter and chroot modes.
+func (r *execRunner) lookPath(name string) error {
+	var path string
+	switch {
+	case r.mode == execModeHost:
+		_, err := exec.LookPath(name)
+		return err
+	case !filepath.IsAbs(name):
+		return nil
+	case r.mode == execModeNsenter:
+		path = procFilePath(filepath.Join("1/root", name))
+	case r.mode == execModeChroot:
+		path = rootfsFilePath(name)
+	}
+	_, err := os.Stat(path)
+	return err
+}
+
+// wrappers returns the commands every command is wrapped with.
+func (r *execRunner) wrappers() []string {
+	var wrappers []string
+	switch r.mode {
+	case execModeNsenter:
+		wrappers = append(wrappers, "nsenter")
+	case execModeChroot:
+		wrappers = append(wrappers, "chroot")
+	}
+	if r.ioniceClass != "" {
+		wrappers = append(wrappers, "ionice")
+	}
+	if r.nice != 0 {
+		wrappers = append(wrappers, "nice")
+	}
+	return wrappers
+}
+
+// lookWrappers checks that the wrappers are installed, a missing one would
+// otherwise be taken for the wrapped command not being installed.
+func (r *execRunner) lookWrappers() error {
+	for _, wrapper := range r.wrappers() {
+		if _, err := exec.LookPath(wrapper); err != nil {
+			return fmt.Errorf("%s isn't installed: %w", wrapper, err)
+		}
+	}
+	return nil
+}
+
+// command wraps the command with nsenter or chroot, nice and ionice when they are configured.
+func (r *execRunner) command(name string, args []string) (string, []string) {
+	argv := append([]string{name}, args...)
+	switch r.mode {
+	case execModeNsenter:
+		argv = append([]string{"nsenter", "--target", "1", "--mount", "--"}, argv...)
+	case execModeChroot:
+		argv = append([]string{"chroot", rootfsPath}, argv...)
+	}
+	if r.ioniceClass != "" {
+		argv = append([]string{"ionice", "-c", r.ioniceClass}, argv...)
+	}
+	if r.nice != 0 {
+		argv = append([]string{"nice", "-n", strconv.Itoa(r.nice)}, argv...)
+	}
+	return argv[0], argv[1:]
+}
+
+// limitedBuffer keeps the first limit bytes written to it and discards the others.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if b.limit > 0 && b.buf.Len()+len(p) > b.limit {
+		p = p[:b.limit-b.buf.Len()]
+		b.truncated = true
+	}
+	b.buf.Write(p)
+	return n, nil
+}
+
+func (b *limitedBuffer) Bytes() []byte {
+	return b.buf.Bytes()
+}
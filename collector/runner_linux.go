@@ -0,0 +1,18 @@
+package collector
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts the command in its own process group, so that the
+// processes it spawns are killed along with it.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process != nil {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package collector
+
+import (
+	"os/exec"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process != nil {
+		cmd.Process.Kill()
+	}
+}
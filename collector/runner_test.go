@@ -0,0 +1,86 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func newTestExecRunner(maxOutputBytes int) *execRunner {
+	return &execRunner{mode: execModeHost, maxOutputBytes: maxOutputBytes, logger: zap.NewNop()}
+}
+
+func TestExecRunnerOutput(t *testing.T) {
+	out, err := newTestExecRunner(0).Run(context.Background(), "sh", "-c", "echo $LC_ALL; echo ignored >&2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(out); got != "C\n" {
+		t.Errorf("got stdout %q, want %q", got, "C\n")
+	}
+
+	_, err = newTestExecRunner(0).Run(context.Background(), "sh", "-c", "echo bad input >&2; exit 2")
+	var execErr *ExecError
+	if !errors.As(err, &execErr) || execErr.ExitCode != 2 || execErr.Stderr != "bad input" {
+		t.Errorf("got %#v, want an ExecError with exit code 2 and the stderr", err)
+	}
+}
+
+func TestExecRunnerOutputLimit(t *testing.T) {
+	_, err := newTestExecRunner(10).Run(context.Background(), "sh", "-c", "printf 0123456789abcdef")
+	if !errors.Is(err, errOutputLimit) {
+		t.Errorf("got %v, want %v", err, errOutputLimit)
+	}
+	if _, err := newTestExecRunner(16).Run(context.Background(), "sh", "-c", "printf 0123456789abcdef"); err != nil {
+		t.Errorf("output within the limit: %v", err)
+	}
+}
+
+func TestExecRunnerNotInstalled(t *testing.T) {
+	_, err := newTestExecRunner(0).Run(context.Background(), "fs-exporter-missing-binary")
+	if !IsNoDataError(err) {
+		t.Errorf("got %v, want a no data error", err)
+	}
+}
+
+func TestExecRunnerWrappers(t *testing.T) {
+	path := os.Getenv("PATH")
+	t.Cleanup(func() { os.Setenv("PATH", path) })
+	os.Setenv("PATH", t.TempDir())
+
+	if err := newTestExecRunner(0).lookWrappers(); err != nil {
+		t.Errorf("host mode: %v", err)
+	}
+	for _, tc := range []struct {
+		r       *execRunner
+		wrapper string
+	}{
+		{&execRunner{mode: execModeNsenter}, "nsenter"},
+		{&execRunner{mode: execModeChroot}, "chroot"},
+		{&execRunner{mode: execModeHost, nice: 10}, "nice"},
+		{&execRunner{mode: execModeHost, ioniceClass: "idle"}, "ionice"},
+	} {
+		if err := tc.r.lookWrappers(); !errors.Is(err, exec.ErrNotFound) || !strings.Contains(err.Error(), tc.wrapper) {
+			t.Errorf("got %v, want %s not found", err, tc.wrapper)
+		}
+	}
+}
+
+func TestExecRunnerTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	_, err := newTestExecRunner(0).Run(ctx, "sh", "-c", "sleep 30")
+	if !IsTimeoutError(err) {
+		t.Errorf("got %v, want a timeout", err)
+	}
+	if elapsed := time.Since(begin); elapsed > 5*time.Second {
+		t.Errorf("the command was killed after %s", elapsed)
+	}
+}
@@ -0,0 +1,73 @@
+package collector
+
+import (
+	"sort"
+	"time"
+)
+
+// The states of a circuit breaker.
+const (
+	BreakerDisabled = "disabled"
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+)
+
+// Status is the state of a registered collector.
+type Status struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	// Background reports whether the collector runs on its own schedule.
+	Background bool `json:"background"`
+	// LastRun is nil until the collector completed a run.
+	LastRun             *time.Time `json:"last_run,omitempty"`
+	LastDurationSeconds float64    `json:"last_duration_seconds"`
+	LastError           string     `json:"last_error,omitempty"`
+	Breaker             string     `json:"breaker"`
+	BreakerNextRetry    *time.Time `json:"breaker_next_retry,omitempty"`
+}
+
+// Statuses returns the state of every registered collector, sorted by name.
+func Statuses() []Status {
+	factoryMutex.Lock()
+	statuses := make([]Status, 0, len(collectorState))
+	for name, enabled := range collectorState {
+		statuses = append(statuses, Status{Name: name, Enabled: *enabled})
+	}
+	factoryMutex.Unlock()
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+
+	collectorMutex.Lock()
+	defer collectorMutex.Unlock()
+	for i := range statuses {
+		s := &statuses[i]
+		r, ok := collectorRuns[s.Name]
+		if !ok {
+			continue
+		}
+		_, s.Background = backgroundCollectors[s.Name]
+		r.mtx.Lock()
+		if !r.lastRun.IsZero() {
+			lastRun := r.lastRun
+			s.LastRun = &lastRun
+			s.LastDurationSeconds = r.lastDuration.Seconds()
+			if r.lastErr != nil {
+				s.LastError = r.lastErr.Error()
+			}
+		}
+		r.mtx.Unlock()
+		s.Breaker, s.BreakerNextRetry = r.breaker.state()
+	}
+	return statuses
+}
+
+// Ready reports whether every enabled collector completed a run, and returns
+// the ones which haven't otherwise.
+func Ready() (bool, []string) {
+	var pending []string
+	for _, s := range Statuses() {
+		if s.Enabled && s.LastRun == nil {
+			pending = append(pending, s.Name)
+		}
+	}
+	return len(pending) == 0, pending
+}
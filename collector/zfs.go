@@ -1,27 +1,40 @@
 package collector
 
 import (
+	"context"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 )
 
 // ZfsCollector defines structure of zfs stats
 type ZfsCollector struct {
+	runner Runner
 	logger *zap.Logger
 }
 
 // Update implements Collector.Update
-func (c *ZfsCollector) Update(ch chan<- prometheus.Metric) error {
+func (c *ZfsCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
 	return nil
 }
 
 // NewZfsCollector returns a new Collector exposing zfs stats.
 func NewZfsCollector(logger *zap.Logger) (Collector, error) {
+	runner, err := newRunner("zfs", logger)
+	if err != nil {
+		return nil, err
+	}
+	return NewZfsCollectorWithRunner(runner, logger), nil
+}
+
+// NewZfsCollectorWithRunner returns a new Collector exposing zfs stats,
+// which runs its commands with runner, e.g. a fake one in tests.
+func NewZfsCollectorWithRunner(runner Runner, logger *zap.Logger) Collector {
 	return &ZfsCollector{
+		runner: runner,
 		logger: logger,
-	}, nil
+	}
 }
 
 func init() {
-	registerCollector("zfs", NewZfsCollector)
+	registerCollector("zfs", defaultEnabled, NewZfsCollector)
 }
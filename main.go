@@ -2,7 +2,6 @@ package main
 
 import (
 	"math/rand"
-	_ "net/http/pprof"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -15,19 +14,4 @@ func main() {
 
 	rootCmd := cmd.NewFSExporterCommand()
 	cobra.CheckErr(rootCmd.Execute())
-
-	// closeC := pkg.NewCloseNotifier()
-	// c := make(chan os.Signal, 1)
-	// signal.Notify(c, os.Interrupt)
-	// go func() {
-	// 	for sig := range c {
-	// 		logger.Warn("fs expoerter received signal: ", zap.Any("Signal", sig))
-	// 		if os.Interrupt == sig {
-	// 			closeC.Close()
-	// 			os.Exit(1)
-	// 		}
-	// 	}
-	// }()
-
-	// <-closeC.CloseNotify()
 }
@@ -0,0 +1,103 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// unixPrefix is the scheme of the listen addresses of Unix sockets.
+	unixPrefix = "unix://"
+	// listenFdsStart is the first file descriptor passed by systemd.
+	listenFdsStart = 3
+)
+
+// Listen opens a listener for each of the addresses. An address of the form
+// unix:///path is a Unix socket, created with the permissions of socketMode.
+// The listeners already opened are closed if one of them fails.
+func Listen(addresses []string, socketMode os.FileMode) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, address := range addresses {
+		l, err := listen(address, socketMode)
+		if err != nil {
+			CloseListeners(listeners)
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+func listen(address string, socketMode os.FileMode) (net.Listener, error) {
+	if !strings.HasPrefix(address, unixPrefix) {
+		return net.Listen("tcp", address)
+	}
+	path := strings.TrimPrefix(address, unixPrefix)
+	if path == "" {
+		return nil, fmt.Errorf("invalid listen address %q, expected unix:///path", address)
+	}
+	// Remove the socket left behind by a previous run which didn't exit cleanly.
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, socketMode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// SystemdListeners returns the listeners passed by systemd socket activation,
+// see sd_listen_fds(3).
+func SystemdListeners() ([]net.Listener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, errors.New("no sockets passed by systemd, LISTEN_PID is not set to the exporter pid")
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("no sockets passed by systemd, invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	var listeners []net.Listener
+	for i := 0; i < n; i++ {
+		name := "LISTEN_FD_" + strconv.Itoa(listenFdsStart+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		// FileListener duplicates the file descriptor, the one passed by
+		// systemd can then be closed.
+		f := os.NewFile(uintptr(listenFdsStart+i), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			CloseListeners(listeners)
+			return nil, fmt.Errorf("invalid socket %s passed by systemd: %s", name, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// CloseListeners closes the listeners.
+func CloseListeners(listeners []net.Listener) {
+	for _, l := range listeners {
+		l.Close()
+	}
+}
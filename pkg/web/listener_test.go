@@ -0,0 +1,129 @@
+package web
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestListenUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "exporter.sock")
+	listeners, err := Listen([]string{"127.0.0.1:0", unixPrefix + path}, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseListeners(listeners)
+	if len(listeners) != 2 || listeners[1].Addr().Network() != "unix" {
+		t.Fatalf("got listeners %v, want a TCP and a Unix one", listeners)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&os.ModeSocket == 0 || fi.Mode().Perm() != 0660 {
+		t.Errorf("got socket mode %v, want a socket with 0660", fi.Mode())
+	}
+}
+
+func TestListenStaleUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "exporter.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The socket is left behind, like after a crash.
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+
+	listeners, err := Listen([]string{unixPrefix + path}, 0600)
+	if err != nil {
+		t.Fatalf("the stale socket wasn't removed: %v", err)
+	}
+	CloseListeners(listeners)
+}
+
+func TestListenInvalidAddresses(t *testing.T) {
+	// A file which isn't a socket is never removed.
+	path := filepath.Join(t.TempDir(), "exporter.sock")
+	if err := ioutil.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, address := range []string{unixPrefix, unixPrefix + path, "127.0.0.1:http-alt-invalid"} {
+		if _, err := Listen([]string{"127.0.0.1:0", address}, 0600); err == nil {
+			t.Errorf("%q: got no error", address)
+		}
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("the file was removed: %v", err)
+	}
+}
+
+func TestSystemdListenersEnvironment(t *testing.T) {
+	for _, tc := range []struct {
+		name, pid, fds string
+	}{
+		{"unset", "", ""},
+		{"other process", strconv.Itoa(os.Getppid()), "1"},
+		{"no socket", strconv.Itoa(os.Getpid()), "0"},
+		{"invalid fds", strconv.Itoa(os.Getpid()), "x"},
+	} {
+		os.Setenv("LISTEN_PID", tc.pid)
+		os.Setenv("LISTEN_FDS", tc.fds)
+		if listeners, err := SystemdListeners(); err == nil {
+			CloseListeners(listeners)
+			t.Errorf("%s: got no error", tc.name)
+		}
+		// The variables aren't inherited by the commands of the collectors.
+		if _, ok := os.LookupEnv("LISTEN_FDS"); ok {
+			t.Errorf("%s: LISTEN_FDS is still set", tc.name)
+		}
+	}
+}
+
+// TestSystemdListeners passes a socket to a child process like systemd does,
+// as the file descriptor 3 with the pid of the child in LISTEN_PID.
+func TestSystemdListeners(t *testing.T) {
+	if addr := os.Getenv("FS_EXPORTER_TEST_SYSTEMD_ADDR"); addr != "" {
+		systemdChild(t, addr)
+		return
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSystemdListeners$")
+	cmd.Env = append(os.Environ(), "FS_EXPORTER_TEST_SYSTEMD_ADDR="+l.Addr().String(), "LISTEN_FDS=1", "LISTEN_FDNAMES=http")
+	cmd.ExtraFiles = []*os.File{f}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("child process failed: %v\n%s", err, out)
+	}
+}
+
+func systemdChild(t *testing.T, addr string) {
+	// systemd sets LISTEN_PID once the child is forked.
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	listeners, err := SystemdListeners()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseListeners(listeners)
+	if len(listeners) != 1 || listeners[0].Addr().String() != addr {
+		t.Fatalf("got listeners %v, want the one of %s", listeners, addr)
+	}
+	for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		if _, ok := os.LookupEnv(name); ok {
+			t.Errorf("%s is still set", name)
+		}
+	}
+}
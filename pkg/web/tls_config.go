@@ -0,0 +1,377 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file is derived from web/tls_config.go of github.com/prometheus/exporter-toolkit,
+// modified to log with zap and to serve several listeners.
+
+package web
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	errNoTLSConfig = errors.New("TLS config is not present")
+)
+
+// Config is the web configuration file, it is compatible with the format of
+// the Prometheus exporter-toolkit.
+type Config struct {
+	TLSConfig  TLSStruct         `yaml:"tls_server_config"`
+	HTTPConfig HTTPStruct        `yaml:"http_server_config"`
+	Users      map[string]secret `yaml:"basic_auth_users"`
+}
+
+// secret is a password hash, it is redacted when the configuration is marshaled.
+type secret string
+
+func (s secret) MarshalYAML() (interface{}, error) {
+	if s != "" {
+		return "<secret>", nil
+	}
+	return nil, nil
+}
+
+// TLSStruct defines the TLS parameters of the server.
+type TLSStruct struct {
+	TLSCertPath              string     `yaml:"cert_file"`
+	TLSKeyPath               string     `yaml:"key_file"`
+	ClientAuth               string     `yaml:"client_auth_type"`
+	ClientCAs                string     `yaml:"client_ca_file"`
+	CipherSuites             []cipher   `yaml:"cipher_suites"`
+	CurvePreferences         []curve    `yaml:"curve_preferences"`
+	MinVersion               tlsVersion `yaml:"min_version"`
+	MaxVersion               tlsVersion `yaml:"max_version"`
+	PreferServerCipherSuites bool       `yaml:"prefer_server_cipher_suites"`
+}
+
+// SetDirectory joins any relative file paths with dir.
+func (t *TLSStruct) SetDirectory(dir string) {
+	t.TLSCertPath = joinDir(dir, t.TLSCertPath)
+	t.TLSKeyPath = joinDir(dir, t.TLSKeyPath)
+	t.ClientCAs = joinDir(dir, t.ClientCAs)
+}
+
+func joinDir(dir, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// HTTPStruct defines the HTTP parameters of the server.
+type HTTPStruct struct {
+	HTTP2   bool              `yaml:"http2"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+}
+
+func getConfig(configPath string) (*Config, error) {
+	content, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{
+		TLSConfig: TLSStruct{
+			MinVersion:               tls.VersionTLS12,
+			MaxVersion:               tls.VersionTLS13,
+			PreferServerCipherSuites: true,
+		},
+		HTTPConfig: HTTPStruct{HTTP2: true},
+	}
+	err = yaml.UnmarshalStrict(content, c)
+	c.TLSConfig.SetDirectory(filepath.Dir(configPath))
+	return c, err
+}
+
+func getTLSConfig(configPath string) (*tls.Config, error) {
+	c, err := getConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	return ConfigToTLSConfig(&c.TLSConfig)
+}
+
+// ConfigToTLSConfig generates the golang tls.Config from the TLSStruct config.
+func ConfigToTLSConfig(c *TLSStruct) (*tls.Config, error) {
+	if c.TLSCertPath == "" && c.TLSKeyPath == "" && c.ClientAuth == "" && c.ClientCAs == "" {
+		return nil, errNoTLSConfig
+	}
+
+	if c.TLSCertPath == "" {
+		return nil, errors.New("missing cert_file")
+	}
+	if c.TLSKeyPath == "" {
+		return nil, errors.New("missing key_file")
+	}
+
+	loadCert := func() (*tls.Certificate, error) {
+		cert, err := tls.LoadX509KeyPair(c.TLSCertPath, c.TLSKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load X509KeyPair: %w", err)
+		}
+		return &cert, nil
+	}
+
+	// Confirm that certificate and key paths are valid.
+	if _, err := loadCert(); err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		MinVersion:               (uint16)(c.MinVersion),
+		MaxVersion:               (uint16)(c.MaxVersion),
+		PreferServerCipherSuites: c.PreferServerCipherSuites,
+	}
+
+	cfg.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return loadCert()
+	}
+
+	var cf []uint16
+	for _, c := range c.CipherSuites {
+		cf = append(cf, (uint16)(c))
+	}
+	if len(cf) > 0 {
+		cfg.CipherSuites = cf
+	}
+
+	var cp []tls.CurveID
+	for _, c := range c.CurvePreferences {
+		cp = append(cp, (tls.CurveID)(c))
+	}
+	if len(cp) > 0 {
+		cfg.CurvePreferences = cp
+	}
+
+	if c.ClientCAs != "" {
+		clientCAPool := x509.NewCertPool()
+		clientCAFile, err := ioutil.ReadFile(c.ClientCAs)
+		if err != nil {
+			return nil, err
+		}
+		clientCAPool.AppendCertsFromPEM(clientCAFile)
+		cfg.ClientCAs = clientCAPool
+	}
+
+	switch c.ClientAuth {
+	case "RequestClientCert":
+		cfg.ClientAuth = tls.RequestClientCert
+	case "RequireAnyClientCert", "RequireClientCert": // Preserved for backwards compatibility.
+		cfg.ClientAuth = tls.RequireAnyClientCert
+	case "VerifyClientCertIfGiven":
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	case "RequireAndVerifyClientCert":
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	case "", "NoClientCert":
+		cfg.ClientAuth = tls.NoClientCert
+	default:
+		return nil, errors.New("Invalid ClientAuth: " + c.ClientAuth)
+	}
+
+	if c.ClientCAs != "" && cfg.ClientAuth == tls.NoClientCert {
+		return nil, errors.New("Client CA's have been configured without a Client Auth Policy")
+	}
+
+	return cfg, nil
+}
+
+// Validate validates the web configuration file.
+func Validate(configPath string) error {
+	if configPath == "" {
+		return nil
+	}
+	c, err := getConfig(configPath)
+	if err != nil {
+		return err
+	}
+	if err := validateUsers(configPath); err != nil {
+		return err
+	}
+	if err := validateHeaderConfig(c.HTTPConfig.Headers); err != nil {
+		return err
+	}
+	_, err = ConfigToTLSConfig(&c.TLSConfig)
+	if err == errNoTLSConfig {
+		return nil
+	}
+	return err
+}
+
+// ServeMultiple starts the server on all the listeners, and returns the first
+// error. The web configuration file enables TLS and basic authentication, it
+// is read again for every TLS handshake and request so that the certificates
+// and users are reloaded when it changes. Without a configuration file the
+// server serves plain HTTP.
+func ServeMultiple(listeners []net.Listener, server *http.Server, configPath string, logger *zap.Logger) error {
+	serve, err := prepare(server, configPath, logger)
+	if err != nil {
+		return err
+	}
+	errC := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			errC <- serve(l)
+		}(l)
+	}
+	return <-errC
+}
+
+// prepare sets up the handler and the TLS configuration of the server, and
+// returns the function serving a listener.
+func prepare(server *http.Server, configPath string, logger *zap.Logger) (func(net.Listener) error, error) {
+	if configPath == "" {
+		logger.Info("TLS is disabled.", zap.Bool("http2", false))
+		return server.Serve, nil
+	}
+
+	if err := Validate(configPath); err != nil {
+		return nil, err
+	}
+
+	var handler http.Handler = http.DefaultServeMux
+	if server.Handler != nil {
+		handler = server.Handler
+	}
+	server.Handler = &webHandler{
+		tlsConfigPath: configPath,
+		logger:        logger,
+		handler:       handler,
+		cache:         newCache(),
+	}
+
+	c, err := getConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := ConfigToTLSConfig(&c.TLSConfig)
+	switch err {
+	case nil:
+		if !c.HTTPConfig.HTTP2 {
+			server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+		}
+		// Valid TLS config.
+		logger.Info("TLS is enabled.", zap.Bool("http2", c.HTTPConfig.HTTP2))
+	case errNoTLSConfig:
+		// No TLS config, back to plain HTTP.
+		logger.Info("TLS is disabled.", zap.Bool("http2", false))
+		return server.Serve, nil
+	default:
+		// Invalid TLS config.
+		return nil, err
+	}
+
+	server.TLSConfig = config
+
+	// Set the GetConfigForClient method of the HTTPS server so that the config
+	// and certs are reloaded on new connections.
+	server.TLSConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		return getTLSConfig(configPath)
+	}
+	return func(l net.Listener) error {
+		return server.ServeTLS(l, "", "")
+	}, nil
+}
+
+type cipher uint16
+
+func (c *cipher) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	err := unmarshal((*string)(&s))
+	if err != nil {
+		return err
+	}
+	for _, cs := range tls.CipherSuites() {
+		if cs.Name == s {
+			*c = (cipher)(cs.ID)
+			return nil
+		}
+	}
+	return errors.New("unknown cipher: " + s)
+}
+
+func (c cipher) MarshalYAML() (interface{}, error) {
+	return tls.CipherSuiteName((uint16)(c)), nil
+}
+
+type curve tls.CurveID
+
+var curves = map[string]curve{
+	"CurveP256": (curve)(tls.CurveP256),
+	"CurveP384": (curve)(tls.CurveP384),
+	"CurveP521": (curve)(tls.CurveP521),
+	"X25519":    (curve)(tls.X25519),
+}
+
+func (c *curve) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	err := unmarshal((*string)(&s))
+	if err != nil {
+		return err
+	}
+	if curveid, ok := curves[s]; ok {
+		*c = curveid
+		return nil
+	}
+	return errors.New("unknown curve: " + s)
+}
+
+func (c *curve) MarshalYAML() (interface{}, error) {
+	for s, curveid := range curves {
+		if *c == curveid {
+			return s, nil
+		}
+	}
+	return fmt.Sprintf("%v", c), nil
+}
+
+type tlsVersion uint16
+
+var tlsVersions = map[string]tlsVersion{
+	"TLS13": (tlsVersion)(tls.VersionTLS13),
+	"TLS12": (tlsVersion)(tls.VersionTLS12),
+	"TLS11": (tlsVersion)(tls.VersionTLS11),
+	"TLS10": (tlsVersion)(tls.VersionTLS10),
+}
+
+func (tv *tlsVersion) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	err := unmarshal((*string)(&s))
+	if err != nil {
+		return err
+	}
+	if v, ok := tlsVersions[s]; ok {
+		*tv = v
+		return nil
+	}
+	return errors.New("unknown TLS version: " + s)
+}
+
+func (tv *tlsVersion) MarshalYAML() (interface{}, error) {
+	for s, v := range tlsVersions {
+		if *tv == v {
+			return s, nil
+		}
+	}
+	return fmt.Sprintf("%v", tv), nil
+}
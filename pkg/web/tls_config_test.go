@@ -0,0 +1,325 @@
+package web
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testPKI is a CA with the certificates it signed, written to dir.
+type testPKI struct {
+	dir    string
+	ca     *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	caPool *x509.CertPool
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	p := &testPKI{dir: t.TempDir()}
+	p.ca, p.caKey = p.issue(t, "ca", &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	})
+	p.caPool = x509.NewCertPool()
+	p.caPool.AddCert(p.ca)
+	return p
+}
+
+// issue signs the template with the CA, or self-signs it for the CA itself,
+// and writes the certificate and its key to <name>.crt and <name>.key.
+func (p *testPKI) issue(t *testing.T, name string, template *x509.Certificate) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	parent, parentKey := template, key
+	if p.ca != nil {
+		parent, parentKey = p.ca, p.caKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(p.dir, name+".crt"), string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+	writeFile(t, filepath.Join(p.dir, name+".key"), string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})))
+	return cert, key
+}
+
+// clientCert issues a client certificate signed by the CA.
+func (p *testPKI) clientCert(t *testing.T) tls.Certificate {
+	t.Helper()
+	p.issue(t, "client", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "client"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	cert, err := tls.LoadX509KeyPair(filepath.Join(p.dir, "client.crt"), filepath.Join(p.dir, "client.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serve serves a handler answering "ok" with the web configuration file, and
+// returns its address.
+func serve(t *testing.T, configPath string) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "ok")
+		}),
+		// The rejected handshakes are expected.
+		ErrorLog: log.New(ioutil.Discard, "", 0),
+	}
+	errC := make(chan error, 1)
+	go func() {
+		errC <- ServeMultiple([]net.Listener{l}, server, configPath, zap.NewNop())
+	}()
+	t.Cleanup(func() {
+		server.Close()
+		if err := <-errC; err != http.ErrServerClosed {
+			t.Errorf("got error %v, want %v", err, http.ErrServerClosed)
+		}
+	})
+	return l.Addr().String()
+}
+
+func get(client *http.Client, url string, user, password string) (int, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	if user != "" {
+		req.SetBasicAuth(user, password)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func TestServeTLS(t *testing.T) {
+	p := newTestPKI(t)
+	p.issue(t, "server", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "server"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	// The relative paths are resolved from the directory of the file.
+	config := filepath.Join(p.dir, "web.yml")
+	writeFile(t, config, "tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\n")
+	addr := serve(t, config)
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: p.caPool}}}
+	if code, err := get(client, "https://"+addr, "", ""); err != nil || code != http.StatusOK {
+		t.Errorf("got %d, %v over TLS, want %d", code, err, http.StatusOK)
+	}
+	// Go answers the plain HTTP requests sent to a TLS server with a 400.
+	if code, err := get(http.DefaultClient, "http://"+addr, "", ""); err != nil || code != http.StatusBadRequest {
+		t.Errorf("got %d, %v over plain HTTP, want %d", code, err, http.StatusBadRequest)
+	}
+}
+
+func TestServeMutualTLS(t *testing.T) {
+	p := newTestPKI(t)
+	p.issue(t, "server", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "server"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	clientCert := p.clientCert(t)
+	config := filepath.Join(p.dir, "web.yml")
+	writeFile(t, config, `tls_server_config:
+  cert_file: server.crt
+  key_file: server.key
+  client_auth_type: RequireAndVerifyClientCert
+  client_ca_file: ca.crt
+`)
+	addr := serve(t, config)
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:      p.caPool,
+		Certificates: []tls.Certificate{clientCert},
+	}}}
+	if code, err := get(client, "https://"+addr, "", ""); err != nil || code != http.StatusOK {
+		t.Errorf("got %d, %v with a client certificate, want %d", code, err, http.StatusOK)
+	}
+
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: p.caPool}}}
+	if _, err := get(client, "https://"+addr, "", ""); err == nil {
+		t.Error("got no error without a client certificate")
+	}
+
+	// A certificate signed by another CA is rejected.
+	other := newTestPKI(t)
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:      p.caPool,
+		Certificates: []tls.Certificate{other.clientCert(t)},
+	}}}
+	if _, err := get(client, "https://"+addr, "", ""); err == nil {
+		t.Error("got no error with a client certificate of another CA")
+	}
+}
+
+func TestServeBasicAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := filepath.Join(t.TempDir(), "web.yml")
+	writeFile(t, config, fmt.Sprintf("basic_auth_users:\n  alice: %s\n", hash))
+	addr := serve(t, config)
+
+	for _, tc := range []struct {
+		user, password string
+		want           int
+	}{
+		{"alice", "secret", http.StatusOK},
+		// The outcome of the comparison is cached, it must not leak to another password.
+		{"alice", "secret", http.StatusOK},
+		{"alice", "wrong", http.StatusUnauthorized},
+		{"bob", "secret", http.StatusUnauthorized},
+		{"", "", http.StatusUnauthorized},
+	} {
+		code, err := get(http.DefaultClient, "http://"+addr, tc.user, tc.password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tc.want {
+			t.Errorf("%s:%s: got status %d, want %d", tc.user, tc.password, code, tc.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		config string
+		valid  bool
+	}{
+		{"", true},
+		{fmt.Sprintf("basic_auth_users:\n  alice: %s\n", hash), true},
+		{"basic_auth_users:\n  alice: plaintext\n", false},
+		{"unknown: true\n", false},
+		{"tls_server_config:\n  cert_file: missing.crt\n", false},
+		{"tls_server_config:\n  cert_file: missing.crt\n  key_file: missing.key\n", false},
+		{"tls_server_config:\n  client_auth_type: Unknown\n", false},
+	} {
+		config := filepath.Join(dir, "web.yml")
+		writeFile(t, config, tc.config)
+		if err := Validate(config); (err == nil) != tc.valid {
+			t.Errorf("%q: got error %v, want valid %v", tc.config, err, tc.valid)
+		}
+	}
+}
+
+// issueServer issues the server certificate for 127.0.0.1 with the common name.
+func (p *testPKI) issueServer(t *testing.T, commonName string) {
+	t.Helper()
+	p.issue(t, "server", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: commonName},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+}
+
+// handshake returns the common name of the server certificate, or the error of the handshake.
+func handshake(addr string, config *tls.Config) (string, error) {
+	conn, err := tls.Dial("tcp", addr, config)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName, nil
+}
+
+func TestServeTLSReloadsTheCertificate(t *testing.T) {
+	p := newTestPKI(t)
+	p.issueServer(t, "server-1")
+	config := filepath.Join(p.dir, "web.yml")
+	writeFile(t, config, "tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\n")
+	addr := serve(t, config)
+
+	clientConfig := &tls.Config{RootCAs: p.caPool}
+	if name, err := handshake(addr, clientConfig); err != nil || name != "server-1" {
+		t.Errorf("got certificate %q, %v, want server-1", name, err)
+	}
+	// The renewed certificate is used by the next handshake, without a restart.
+	p.issueServer(t, "server-2")
+	if name, err := handshake(addr, clientConfig); err != nil || name != "server-2" {
+		t.Errorf("got certificate %q, %v after the renewal, want server-2", name, err)
+	}
+}
+
+func TestServeTLSMinVersion(t *testing.T) {
+	p := newTestPKI(t)
+	p.issueServer(t, "server")
+	config := filepath.Join(p.dir, "web.yml")
+	writeFile(t, config, "tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\n  min_version: TLS12\n")
+	addr := serve(t, config)
+
+	for _, tc := range []struct {
+		version uint16
+		ok      bool
+	}{
+		{tls.VersionTLS11, false},
+		{tls.VersionTLS12, true},
+		{tls.VersionTLS13, true},
+	} {
+		_, err := handshake(addr, &tls.Config{RootCAs: p.caPool, MinVersion: tc.version, MaxVersion: tc.version})
+		if (err == nil) != tc.ok {
+			t.Errorf("TLS version %#x: got error %v, want accepted %v", tc.version, err, tc.ok)
+		}
+	}
+}